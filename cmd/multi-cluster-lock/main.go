@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/the-gigi/go-k8s/pkg/multi_cluster_lock"
+)
+
+const usage = `Inspect a multi-cluster lock kept in a Github gist and evict a wedged leader.
+
+Usage:
+  multi-cluster-lock [flags] status
+  multi-cluster-lock [flags] -reason <why> release
+  multi-cluster-lock [flags] -reason <why> -holder <identity> steal
+
+status   shows the holder, the acquire and renew times, the remaining lease and the transitions
+release  clears the holder, even if its lease is still valid, so another instance can acquire the lock right away
+steal    waits until the lease of the current holder expired and hands the lock over to -holder
+
+The Github API token is read from $GITHUB_API_TOKEN or from -token-file. To authenticate as a Github App
+installation instead, pass -app-id, -installation-id and -app-key-file. api.github.com doesn't accept
+installation tokens for gists, so this only works with a server that does (e.g. a proxy, see -base-url).
+If the lock records are signed (and encrypted), pass the secret of the lock with -secret-file (and -encrypt).
+Like with the token, surrounding whitespace (e.g. the trailing newline of the file) isn't part of the secret.
+
+Flags:
+`
+
+// tokenSource returns a Github App token source if appKeyFile is set, and a source for the token in
+// $GITHUB_API_TOKEN or tokenFile otherwise
+func tokenSource(baseURL string, tokenFile string, appId int64, installationId int64, appKeyFile string) (source multi_cluster_lock.TokenSource, err error) {
+	if appKeyFile != "" {
+		var key []byte
+		key, err = os.ReadFile(appKeyFile)
+		if err != nil {
+			return
+		}
+
+		return multi_cluster_lock.NewGithubAppTokenSource(multi_cluster_lock.GithubAppOptions{
+			AppID:          appId,
+			InstallationID: installationId,
+			PrivateKey:     key,
+			BaseURL:        baseURL,
+		})
+	}
+
+	token, err := readToken(tokenFile)
+	if err != nil {
+		return
+	}
+
+	source = multi_cluster_lock.StaticTokenSource(token)
+	return
+}
+
+func readToken(tokenFile string) (token string, err error) {
+	token = os.Getenv("GITHUB_API_TOKEN")
+	if token != "" {
+		return
+	}
+
+	data, err := os.ReadFile(tokenFile)
+	if err != nil {
+		return
+	}
+
+	token = strings.TrimSpace(string(data))
+	return
+}
+
+func printStatus(status *multi_cluster_lock.LockStatus) {
+	holder := status.HolderIdentity
+	if holder == "" {
+		holder = "<none>"
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "Holder:\t%s\n", holder)
+	fmt.Fprintf(w, "Acquired:\t%s (%s ago)\n", status.AcquireTime.Format(time.RFC3339), time.Since(status.AcquireTime).Round(time.Second))
+	fmt.Fprintf(w, "Renewed:\t%s (%s ago)\n", status.RenewTime.Format(time.RFC3339), time.Since(status.RenewTime).Round(time.Second))
+	fmt.Fprintf(w, "Lease duration:\t%s\n", status.LeaseDuration)
+	fmt.Fprintf(w, "Remaining:\t%s\n", status.Remaining.Round(time.Second))
+	fmt.Fprintf(w, "Transitions:\t%d\n", status.LeaderTransitions)
+	fmt.Fprintf(w, "Fencing token:\t%d\n", status.FencingToken)
+	if m := status.Metadata; m != nil {
+		fmt.Fprintf(w, "Cluster:\t%s\n", m.ClusterName)
+		fmt.Fprintf(w, "Region:\t%s\n", m.Region)
+		fmt.Fprintf(w, "Priority:\t%d\n", m.Priority)
+		fmt.Fprintf(w, "Version:\t%s\n", m.Version)
+	}
+	_ = w.Flush()
+
+	if len(status.History) == 0 {
+		return
+	}
+
+	fmt.Println("\nHistory:")
+	w = tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ACQUIRED\tHOLDER\tTOKEN\tOPERATOR\tREASON")
+	for _, t := range status.History {
+		holder := t.HolderIdentity
+		if holder == "" {
+			holder = "<released>"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\n", t.AcquireTime.Format(time.RFC3339), holder, t.FencingToken, t.Operator, t.Reason)
+	}
+	_ = w.Flush()
+}
+
+// steal waits until the lease of the current holder expired by our clock and hands the lock over to holder.
+// The inspector measures the lease from its first read, so it waits a full lease after reading the record,
+// whatever the RenewTime says. If the holder renews in the meantime, Steal() fails with a Conflict.
+func steal(inspector multi_cluster_lock.Inspector, holder string, audit multi_cluster_lock.Audit) (err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	status, err := inspector.Status(ctx)
+	cancel()
+	if err != nil {
+		return
+	}
+
+	if status.HolderIdentity != "" {
+		fmt.Printf("Waiting %s for the lease of %s to expire\n", status.LeaseDuration, status.HolderIdentity)
+		time.Sleep(status.LeaseDuration)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	return inspector.Steal(ctx, holder, audit)
+}
+
+func main() {
+	home, _ := os.UserHomeDir()
+
+	gistId := flag.String("gist", "", "the ID of the gist that holds the lock (required)")
+	filename := flag.String("file", "", "the file of the lock in the gist (required)")
+	baseURL := flag.String("base-url", "", "the Github API base URL (defaults to https://api.github.com)")
+	tokenFile := flag.String("token-file", filepath.Join(home, "github_api_token.txt"), "the file with the Github API token")
+	operator := flag.String("operator", os.Getenv("USER"), "who performs a release or steal")
+	reason := flag.String("reason", "", "why you release or steal the lock (required for release and steal)")
+	holder := flag.String("holder", "", "the identity that gets the lock (required for steal)")
+	secretFile := flag.String("secret-file", "", "the file with the secret that signs the lock records, if any")
+	encrypt := flag.Bool("encrypt", false, "the lock records are encrypted with the secret")
+	appId := flag.Int64("app-id", 0, "the ID of the Github App to authenticate as (not accepted for gists by api.github.com)")
+	installationId := flag.Int64("installation-id", 0, "the ID of the installation of the Github App")
+	appKeyFile := flag.String("app-key-file", "", "the file with the private key of the Github App")
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if *gistId == "" || *filename == "" || flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	tokens, err := tokenSource(*baseURL, *tokenFile, *appId, *installationId, *appKeyFile)
+	if err != nil {
+		log.Fatalf("Failed to read the Github API credentials: %v", err)
+	}
+
+	cli, err := multi_cluster_lock.NewGistClientWithOptions("", multi_cluster_lock.GistClientOptions{BaseURL: *baseURL, TokenSource: tokens})
+	if err != nil {
+		log.Fatalf("Failed to create the gist client: %v", err)
+	}
+
+	backend, err := multi_cluster_lock.NewGistBackendWithClient(*gistId, *filename, cli)
+	if err != nil {
+		log.Fatalf("Failed to access the gist: %v", err)
+	}
+
+	options := multi_cluster_lock.LockOptions{Encrypt: *encrypt}
+	if *secretFile != "" {
+		options.Secret, err = os.ReadFile(*secretFile)
+		if err != nil {
+			log.Fatalf("Failed to read the secret: %v", err)
+		}
+	}
+
+	inspector, err := multi_cluster_lock.NewInspector(backend, options)
+	if err != nil {
+		log.Fatalf("Failed to create the inspector: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	audit := multi_cluster_lock.Audit{Operator: *operator, Reason: *reason}
+	switch command := flag.Arg(0); command {
+	case "status":
+		var status *multi_cluster_lock.LockStatus
+		status, err = inspector.Status(ctx)
+		if err == nil {
+			printStatus(status)
+		}
+	case "release":
+		err = inspector.Release(ctx, audit)
+		if err == nil {
+			fmt.Println("Released the lock")
+		}
+	case "steal":
+		err = steal(inspector, *holder, audit)
+		if err == nil {
+			fmt.Printf("Handed the lock over to %s\n", *holder)
+		}
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if err != nil {
+		log.Fatalf("Failed to %s: %v", flag.Arg(0), err)
+	}
+}
@@ -99,6 +99,20 @@ func (s *ClientTestSuite) TestGetPodsWithDynamicClient() {
 	s.Require().Equal(app, "test-deployment")
 }
 
+func (s *ClientTestSuite) TestGetPodsWithTypedResource() {
+	dynamicClient, err := NewDynamicClient(kubeConfigFile, "")
+	s.Require().Nil(err)
+
+	podResource, err := NewTypedResource[corev1.Pod](dynamicClient)
+	s.Require().Nil(err)
+
+	pods, err := podResource.List(context.Background(), "ns-1", metav1.ListOptions{})
+	s.Require().Nil(err)
+	s.Require().Len(pods, 3)
+	s.Require().Equal(pods[0].ObjectMeta.Labels["app"], "test-deployment")
+	s.Require().Equal(pods[0].Spec.Containers[0].Image, testImage)
+}
+
 func (s *ClientTestSuite) TestGetPodsWithClientset() {
 	clientset, err := NewClientset(kubeConfigFile, "")
 	s.Require().Nil(err)
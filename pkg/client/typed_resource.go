@@ -0,0 +1,230 @@
+package client
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes/scheme"
+)
+
+// WatchEvent is an event of a TypedResource watch
+type WatchEvent[T any] struct {
+	Type   watch.EventType
+	Object T
+	Err    error // set for Error events and objects that can't be converted to T
+}
+
+// TypedResource accesses the resources of kind T (e.g. corev1.Pod) through a DynamicClient.
+//
+// Objects are converted from and to unstructured objects, so callers work with T only.
+// Use an empty namespace for cluster-scoped resources or to list and watch all namespaces.
+type TypedResource[T any] interface {
+	Get(ctx context.Context, namespace string, name string, options metav1.GetOptions) (obj T, err error)
+	List(ctx context.Context, namespace string, options metav1.ListOptions) (objects []T, err error)
+	Create(ctx context.Context, obj T, options metav1.CreateOptions) (created T, err error)
+	Update(ctx context.Context, obj T, options metav1.UpdateOptions) (updated T, err error)
+	Patch(ctx context.Context, namespace string, name string, pt types.PatchType, data []byte, options metav1.PatchOptions) (patched T, err error)
+	Delete(ctx context.Context, namespace string, name string, options metav1.DeleteOptions) error
+
+	// Watch sends the events of the resources to events until ctx is done or the server closes the watch
+	Watch(ctx context.Context, namespace string, options metav1.ListOptions) (events <-chan WatchEvent[T], err error)
+
+	// GroupVersionKind returns the kind of T
+	GroupVersionKind() schema.GroupVersionKind
+}
+
+type typedResource[T any] struct {
+	gvk      schema.GroupVersionKind
+	resource dynamic.NamespaceableResourceInterface
+}
+
+func fromUnstructured[T any](u *unstructured.Unstructured) (obj T, err error) {
+	err = runtime.DefaultUnstructuredConverter.FromUnstructured(u.Object, &obj)
+	return
+}
+
+func (r *typedResource[T]) toUnstructured(obj T) (u *unstructured.Unstructured, err error) {
+	m, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&obj)
+	if err != nil {
+		return
+	}
+
+	u = &unstructured.Unstructured{Object: m}
+	u.SetGroupVersionKind(r.gvk)
+	return
+}
+
+func (r *typedResource[T]) Get(ctx context.Context, namespace string, name string, options metav1.GetOptions) (obj T, err error) {
+	u, err := r.resource.Namespace(namespace).Get(ctx, name, options)
+	if err != nil {
+		return
+	}
+	return fromUnstructured[T](u)
+}
+
+func (r *typedResource[T]) List(ctx context.Context, namespace string, options metav1.ListOptions) (objects []T, err error) {
+	list, err := r.resource.Namespace(namespace).List(ctx, options)
+	if err != nil {
+		return
+	}
+
+	for i := range list.Items {
+		var obj T
+		obj, err = fromUnstructured[T](&list.Items[i])
+		if err != nil {
+			return
+		}
+		objects = append(objects, obj)
+	}
+	return
+}
+
+func (r *typedResource[T]) Create(ctx context.Context, obj T, options metav1.CreateOptions) (created T, err error) {
+	u, err := r.toUnstructured(obj)
+	if err != nil {
+		return
+	}
+
+	u, err = r.resource.Namespace(u.GetNamespace()).Create(ctx, u, options)
+	if err != nil {
+		return
+	}
+	return fromUnstructured[T](u)
+}
+
+func (r *typedResource[T]) Update(ctx context.Context, obj T, options metav1.UpdateOptions) (updated T, err error) {
+	u, err := r.toUnstructured(obj)
+	if err != nil {
+		return
+	}
+
+	u, err = r.resource.Namespace(u.GetNamespace()).Update(ctx, u, options)
+	if err != nil {
+		return
+	}
+	return fromUnstructured[T](u)
+}
+
+func (r *typedResource[T]) Patch(ctx context.Context, namespace string, name string, pt types.PatchType, data []byte, options metav1.PatchOptions) (patched T, err error) {
+	u, err := r.resource.Namespace(namespace).Patch(ctx, name, pt, data, options)
+	if err != nil {
+		return
+	}
+	return fromUnstructured[T](u)
+}
+
+func (r *typedResource[T]) Delete(ctx context.Context, namespace string, name string, options metav1.DeleteOptions) error {
+	return r.resource.Namespace(namespace).Delete(ctx, name, options)
+}
+
+// toEvent converts an event of the dynamic client
+func (r *typedResource[T]) toEvent(e watch.Event) (event WatchEvent[T]) {
+	event.Type = e.Type
+	if e.Type == watch.Error {
+		event.Err = apierrors.FromObject(e.Object)
+		return
+	}
+
+	u, ok := e.Object.(*unstructured.Unstructured)
+	if !ok {
+		event.Err = errors.Errorf("unexpected object %T", e.Object)
+		return
+	}
+	event.Object, event.Err = fromUnstructured[T](u)
+	return
+}
+
+func (r *typedResource[T]) Watch(ctx context.Context, namespace string, options metav1.ListOptions) (events <-chan WatchEvent[T], err error) {
+	w, err := r.resource.Namespace(namespace).Watch(ctx, options)
+	if err != nil {
+		return
+	}
+
+	ch := make(chan WatchEvent[T])
+	go func() {
+		defer close(ch)
+		defer w.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case e, ok := <-w.ResultChan():
+				if !ok {
+					return
+				}
+				select {
+				case ch <- r.toEvent(e):
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+
+	events = ch
+	return
+}
+
+func (r *typedResource[T]) GroupVersionKind() schema.GroupVersionKind {
+	return r.gvk
+}
+
+// GroupVersionKindFor returns the kind of the Go type T (e.g. corev1.Pod) registered in s
+func GroupVersionKindFor[T any](s *runtime.Scheme) (gvk schema.GroupVersionKind, err error) {
+	var obj T
+	ro, ok := any(&obj).(runtime.Object)
+	if !ok {
+		err = errors.Errorf("%T is not a Kubernetes object", obj)
+		return
+	}
+
+	gvks, _, err := s.ObjectKinds(ro)
+	if err != nil {
+		return
+	}
+	if len(gvks) == 0 {
+		err = errors.Errorf("%T has no kind", obj)
+		return
+	}
+
+	gvk = gvks[0]
+	return
+}
+
+// NewTypedResource returns a TypedResource for T, which must be registered in the client-go scheme (e.g. corev1.Pod)
+func NewTypedResource[T any](cli DynamicClient) (r TypedResource[T], err error) {
+	return NewTypedResourceWithScheme[T](cli, scheme.Scheme)
+}
+
+// NewTypedResourceWithScheme returns a TypedResource for T, which must be registered in s (e.g. a custom resource)
+func NewTypedResourceWithScheme[T any](cli DynamicClient, s *runtime.Scheme) (r TypedResource[T], err error) {
+	if cli == nil || s == nil {
+		err = errors.New("dynamic client and scheme can't be nil")
+		return
+	}
+
+	gvk, err := GroupVersionKindFor[T](s)
+	if err != nil {
+		return
+	}
+
+	gvr, err := cli.GroupVersionResourceFor(gvk)
+	if err != nil {
+		return
+	}
+
+	r = &typedResource[T]{
+		gvk:      gvk,
+		resource: cli.Resource(gvr),
+	}
+	return
+}
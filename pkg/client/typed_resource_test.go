@@ -0,0 +1,146 @@
+package client
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+)
+
+// restInterface lets fakeDynamicClient embed rest.Interface next to dynamic.Interface. Its methods aren't used
+type restInterface = rest.Interface
+
+// fakeDynamicClient is a DynamicClient on top of a fake dynamic client that knows the resources in gvrs
+type fakeDynamicClient struct {
+	dynamic.Interface
+	restInterface
+	gvrs map[schema.GroupVersionKind]schema.GroupVersionResource
+}
+
+func (f *fakeDynamicClient) GroupVersionResourceFor(gvk schema.GroupVersionKind) (gvr schema.GroupVersionResource, err error) {
+	gvr, ok := f.gvrs[gvk]
+	if !ok {
+		err = apierrors.NewNotFound(gvr.GroupResource(), gvk.Kind)
+	}
+	return
+}
+
+type TypedResourceTestSuite struct {
+	suite.Suite
+
+	pods TypedResource[corev1.Pod]
+}
+
+func newPod(namespace string, name string) corev1.Pod {
+	return corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name},
+		Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "main", Image: testImage}}},
+	}
+}
+
+func (s *TypedResourceTestSuite) SetupTest() {
+	podsGVR := schema.GroupVersionResource{Group: "", Version: "v1", Resource: "pods"}
+	existing := newPod("ns-1", "existing")
+	cli := &fakeDynamicClient{
+		Interface: fake.NewSimpleDynamicClient(scheme.Scheme, &existing),
+		gvrs:      map[schema.GroupVersionKind]schema.GroupVersionResource{corev1.SchemeGroupVersion.WithKind("Pod"): podsGVR},
+	}
+
+	var err error
+	s.pods, err = NewTypedResource[corev1.Pod](cli)
+	s.Require().Nil(err)
+}
+
+func (s *TypedResourceTestSuite) TestDeriveKindFromType() {
+	s.Require().Equal(corev1.SchemeGroupVersion.WithKind("Pod"), s.pods.GroupVersionKind())
+
+	_, err := GroupVersionKindFor[struct{}](scheme.Scheme)
+	s.Require().NotNil(err)
+	_, err = GroupVersionKindFor[corev1.Pod](runtime.NewScheme())
+	s.Require().NotNil(err)
+
+	_, err = NewTypedResource[corev1.Service](&fakeDynamicClient{})
+	s.Require().NotNil(err)
+}
+
+func (s *TypedResourceTestSuite) TestGetAndList() {
+	ctx := context.Background()
+	pod, err := s.pods.Get(ctx, "ns-1", "existing", metav1.GetOptions{})
+	s.Require().Nil(err)
+	s.Require().Equal("existing", pod.Name)
+	s.Require().Equal(testImage, pod.Spec.Containers[0].Image)
+
+	_, err = s.pods.Get(ctx, "ns-1", "missing", metav1.GetOptions{})
+	s.Require().True(apierrors.IsNotFound(err))
+
+	pods, err := s.pods.List(ctx, "ns-1", metav1.ListOptions{})
+	s.Require().Nil(err)
+	s.Require().Len(pods, 1)
+	s.Require().Equal("existing", pods[0].Name)
+}
+
+func (s *TypedResourceTestSuite) TestCreateUpdatePatchDelete() {
+	ctx := context.Background()
+	created, err := s.pods.Create(ctx, newPod("ns-1", "new"), metav1.CreateOptions{})
+	s.Require().Nil(err)
+	s.Require().Equal("new", created.Name)
+	s.Require().Equal("Pod", created.Kind)
+
+	created.Labels = map[string]string{"app": "test"}
+	updated, err := s.pods.Update(ctx, created, metav1.UpdateOptions{})
+	s.Require().Nil(err)
+	s.Require().Equal("test", updated.Labels["app"])
+
+	patch := []byte(`{"metadata":{"labels":{"app":"patched"}}}`)
+	patched, err := s.pods.Patch(ctx, "ns-1", "new", types.MergePatchType, patch, metav1.PatchOptions{})
+	s.Require().Nil(err)
+	s.Require().Equal("patched", patched.Labels["app"])
+
+	err = s.pods.Delete(ctx, "ns-1", "new", metav1.DeleteOptions{})
+	s.Require().Nil(err)
+	_, err = s.pods.Get(ctx, "ns-1", "new", metav1.GetOptions{})
+	s.Require().True(apierrors.IsNotFound(err))
+}
+
+func (s *TypedResourceTestSuite) TestWatch() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	events, err := s.pods.Watch(ctx, "ns-1", metav1.ListOptions{})
+	s.Require().Nil(err)
+
+	_, err = s.pods.Create(ctx, newPod("ns-1", "watched"), metav1.CreateOptions{})
+	s.Require().Nil(err)
+
+	select {
+	case event := <-events:
+		s.Require().Nil(event.Err)
+		s.Require().Equal(watch.Added, event.Type)
+		s.Require().Equal("watched", event.Object.Name)
+	case <-time.After(5 * time.Second):
+		s.Fail("no watch event")
+	}
+
+	// The events channel is closed when the context is done
+	cancel()
+	s.Require().Eventually(func() bool {
+		_, ok := <-events
+		return !ok
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestTypedResourceTestSuite(t *testing.T) {
+	suite.Run(t, new(TypedResourceTestSuite))
+}
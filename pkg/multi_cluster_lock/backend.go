@@ -0,0 +1,46 @@
+package multi_cluster_lock
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrNotFound is returned by a Backend when there is no lock record yet
+	ErrNotFound = errors.New("lock record not found")
+
+	// ErrConflict is returned by a Backend when a compare-and-swap write fails
+	// because the record was modified since it was read
+	ErrConflict = errors.New("lock record was modified concurrently")
+)
+
+// Backend is the storage of a multi-cluster lock record.
+//
+// The lock algorithm treats the record as opaque bytes. Every record has a version,
+// which is an opaque string that changes whenever the record changes. Writes are
+// compare-and-swap operations that succeed only if the stored version is still the
+// version the caller read.
+type Backend interface {
+	// Get returns the current record and its version.
+	// If there is no record it returns ErrNotFound.
+	Get(ctx context.Context) (record []byte, version string, err error)
+
+	// Update writes the record if the stored version matches version and returns the new version.
+	// An empty version means the caller expects that there is no record yet.
+	// If the stored version is different it returns ErrConflict.
+	Update(ctx context.Context, record []byte, version string) (newVersion string, err error)
+
+	// Describe returns a short human-readable description of the backend
+	Describe() string
+}
+
+// Expirer is an optional interface of backends that can delete a record that isn't written again in time,
+// e.g. by attaching it to an etcd lease.
+//
+// UpdateWithTTL is like Update, but the record may expire ttl after the write. The lock passes the lease
+// duration of the record, so the backend doesn't have to look into the record, which may be sealed.
+type Expirer interface {
+	UpdateWithTTL(ctx context.Context, record []byte, version string, ttl time.Duration) (newVersion string, err error)
+}
@@ -0,0 +1,67 @@
+package multi_cluster_lock
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// testBackendContract verifies that a backend behaves as the Backend interface requires
+func testBackendContract(newBackend func() Backend) {
+	ctx := context.Background()
+	var backend Backend
+
+	BeforeEach(func() {
+		backend = newBackend()
+	})
+
+	It("should return not found when empty", func() {
+		_, _, err := backend.Get(ctx)
+		Ω(err).Should(Equal(ErrNotFound))
+	})
+
+	It("should create, get and update a record", func() {
+		version, err := backend.Update(ctx, []byte("record-1"), "")
+		Ω(err).Should(BeNil())
+		Ω(version).ShouldNot(BeEmpty())
+
+		data, curVersion, err := backend.Get(ctx)
+		Ω(err).Should(BeNil())
+		Ω(string(data)).Should(Equal("record-1"))
+		Ω(curVersion).Should(Equal(version))
+
+		newVersion, err := backend.Update(ctx, []byte("record-2"), version)
+		Ω(err).Should(BeNil())
+		Ω(newVersion).ShouldNot(Equal(version))
+
+		data, curVersion, err = backend.Get(ctx)
+		Ω(err).Should(BeNil())
+		Ω(string(data)).Should(Equal("record-2"))
+		Ω(curVersion).Should(Equal(newVersion))
+	})
+
+	It("should reject writes with a stale version", func() {
+		version, err := backend.Update(ctx, []byte("record-1"), "")
+		Ω(err).Should(BeNil())
+
+		_, err = backend.Update(ctx, []byte("record-2"), version)
+		Ω(err).Should(BeNil())
+
+		_, err = backend.Update(ctx, []byte("record-3"), version)
+		Ω(err).Should(Equal(ErrConflict))
+
+		_, err = backend.Update(ctx, []byte("record-3"), "")
+		Ω(err).Should(Equal(ErrConflict))
+
+		data, _, err := backend.Get(ctx)
+		Ω(err).Should(BeNil())
+		Ω(string(data)).Should(Equal("record-2"))
+	})
+}
+
+var _ = Describe("Backends", func() {
+	Context("memory backend", func() {
+		testBackendContract(NewMemoryBackend)
+	})
+})
@@ -0,0 +1,116 @@
+package multi_cluster_lock
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/the-gigi/go-k8s/pkg/multi_cluster_lock/locktest"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"k8s.io/utils/clock"
+)
+
+var _ = Describe("Lock conformance", func() {
+	Context("gist lock", func() {
+		var server *FakeGistServer
+		var failing atomic.Bool
+
+		locktest.RunLockConformance(locktest.LockConformance{
+			Reset: func() {
+				server = NewFakeGistServer()
+				DeferCleanup(server.Close)
+				server.Put("gist-1", "lock.json", "")
+				failing.Store(false)
+			},
+			NewLock: func(identity string, clock clock.PassiveClock) (lock resourcelock.Interface, err error) {
+				// Every lock has its own client, like the instances in different clusters
+				cli, err := NewGistClientWithOptions("token", GistClientOptions{
+					BaseURL: server.URL,
+					Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+						if failing.Load() {
+							return &http.Response{
+								StatusCode: http.StatusBadGateway,
+								Header:     http.Header{},
+								Body:       http.NoBody,
+								Request:    req,
+							}, nil
+						}
+						return http.DefaultTransport.RoundTrip(req)
+					}),
+				})
+				if err != nil {
+					return
+				}
+				cli.retryBackoff = time.Millisecond
+
+				backend, err := NewGistBackendWithClient("gist-1", "lock.json", cli)
+				if err != nil {
+					return
+				}
+				return NewLockWithOptions(identity, backend, LockOptions{Clock: clock})
+			},
+			Corrupt: func() {
+				server.Put("gist-1", "lock.json", "not a record")
+			},
+			SetFailing: func(f bool) {
+				failing.Store(f)
+			},
+		})
+	})
+
+	Context("quorum lock", func() {
+		var backends []*unavailableBackend
+
+		locktest.RunLockConformance(locktest.LockConformance{
+			Reset: func() {
+				backends = nil
+				for i := 0; i < 3; i++ {
+					backends = append(backends, &unavailableBackend{Backend: NewMemoryBackend()})
+				}
+			},
+			NewLock: func(identity string, clock clock.PassiveClock) (lock resourcelock.Interface, err error) {
+				var locks []resourcelock.Interface
+				for _, backend := range backends {
+					var l resourcelock.Interface
+					l, err = NewLockWithOptions(identity, backend, LockOptions{Clock: clock})
+					if err != nil {
+						return
+					}
+					locks = append(locks, l)
+				}
+				return NewQuorumLockWithOptions(QuorumOptions{Clock: clock}, locks...)
+			},
+			Corrupt: func() {
+				for _, backend := range backends {
+					_, err := backend.Update(context.Background(), []byte("not a record"), "")
+					Ω(err).Should(BeNil())
+				}
+			},
+			SetFailing: func(failing bool) {
+				for _, backend := range backends {
+					backend.down.Store(failing)
+				}
+			},
+		})
+	})
+
+	Context("memory lock", func() {
+		var backend Backend
+
+		locktest.RunLockConformance(locktest.LockConformance{
+			Reset: func() {
+				backend = NewMemoryBackend()
+			},
+			NewLock: func(identity string, clock clock.PassiveClock) (lock resourcelock.Interface, err error) {
+				return NewLockWithOptions(identity, backend, LockOptions{Clock: clock})
+			},
+			Corrupt: func() {
+				_, err := backend.Update(context.Background(), []byte("not a record"), "")
+				Ω(err).Should(BeNil())
+			},
+		})
+	})
+})
@@ -0,0 +1,202 @@
+package multi_cluster_lock
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+	"github.com/the-gigi/go-k8s/pkg/informer"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/klog/v2"
+)
+
+// ControllerOptions configures a leadership-gated Controller
+type ControllerOptions struct {
+	// Factory is started by Run() right away, so the caches are warm on every replica, not just the leader.
+	// The caller stops it. Optional
+	Factory informer.Factory
+
+	// Runner configures the leader election. Its callbacks are called as well
+	Runner RunnerOptions
+
+	// Reconcile runs while we are the leader, after the event handlers caught up with the caches.
+	// ctx is cancelled when we stop leading or the context of Run() is done and carries the fencing token
+	// (see FencingTokenFromContext). With Runner.ReleaseOnCancel the lock is released only after Reconcile
+	// and Runner.OnStartedLeading returned. Optional
+	Reconcile func(ctx context.Context)
+}
+
+// Controller watches resources on every replica but acts on them only on the leader of a multi-cluster election.
+//
+// Add event handlers with AddLeaderEventHandler(). They only get events while we are the leader.
+// When we become the leader they get an OnAdd() for every object in the cache, like from a new informer,
+// so they don't miss what happened while another replica was leading. Live events may arrive in the meantime.
+type Controller interface {
+	// Run starts the informer factory and takes part in the election until ctx is done.
+	// When we lose the leadership we stop acting and run for it again.
+	Run(ctx context.Context)
+
+	// IsLeader returns true while the event handlers get events
+	IsLeader() bool
+
+	// Identity returns our identity in the election
+	Identity() string
+}
+
+type controller struct {
+	options ControllerOptions
+	runner  Runner
+
+	// Event handlers hold a read lock while they dispatch, so the gate doesn't close in the middle of an event
+	m       sync.RWMutex
+	leading atomic.Bool
+	replays []func() // replay the cache of an informer to an event handler
+}
+
+// open starts dispatching events and replays the caches, unless ctx (our leadership) is over already
+func (c *controller) open(ctx context.Context) {
+	c.m.Lock()
+	if ctx.Err() != nil {
+		c.m.Unlock()
+		return
+	}
+	replays := append([]func(){}, c.replays...)
+	c.leading.Store(true)
+	c.m.Unlock()
+
+	// The replays dispatch like live events, so the handlers may call back into the controller
+	for _, replay := range replays {
+		replay()
+	}
+}
+
+// close stops dispatching events. It waits for the events that are being dispatched
+func (c *controller) close() {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	c.leading.Store(false)
+}
+
+// dispatch calls f if we are the leader
+func (c *controller) dispatch(f func()) {
+	c.m.RLock()
+	defer c.m.RUnlock()
+
+	if c.leading.Load() {
+		f()
+	}
+}
+
+// lead runs the work of a leadership until ctx is cancelled. The runner waits for it before it releases the lock
+func (c *controller) lead(ctx context.Context) {
+	c.open(ctx)
+
+	var wg sync.WaitGroup
+	if c.options.Runner.OnStartedLeading != nil {
+		wg.Go(func() { c.options.Runner.OnStartedLeading(ctx) })
+	}
+	if c.options.Reconcile != nil {
+		wg.Go(func() { c.options.Reconcile(ctx) })
+	}
+
+	// The leader election cancels ctx before it calls OnStoppedLeading, but stop acting right away
+	<-ctx.Done()
+	c.close()
+	wg.Wait()
+}
+
+func (c *controller) Run(ctx context.Context) {
+	if c.options.Factory != nil {
+		c.options.Factory.Start()
+	}
+
+	for ctx.Err() == nil {
+		c.runner.Run(ctx)
+	}
+}
+
+func (c *controller) IsLeader() bool {
+	return c.leading.Load()
+}
+
+func (c *controller) Identity() string {
+	return c.runner.Identity()
+}
+
+// gatedEventHandler passes the events of an informer on to handler while the controller is the leader
+type gatedEventHandler[T any] struct {
+	c       *controller
+	handler informer.EventHandler[T]
+}
+
+func (h *gatedEventHandler[T]) OnAdd(obj T) {
+	h.c.dispatch(func() { h.handler.OnAdd(obj) })
+}
+
+func (h *gatedEventHandler[T]) OnUpdate(oldObj T, newObj T) {
+	h.c.dispatch(func() { h.handler.OnUpdate(oldObj, newObj) })
+}
+
+func (h *gatedEventHandler[T]) OnDelete(obj T) {
+	h.c.dispatch(func() { h.handler.OnDelete(obj) })
+}
+
+// AddLeaderEventHandler adds handler to in, so it gets the events of in while c is the leader.
+//
+// Call it before c.Run()
+func AddLeaderEventHandler[T any](c Controller, in informer.Informer[T], handler informer.EventHandler[T]) (err error) {
+	ctrl, ok := c.(*controller)
+	if !ok {
+		err = errors.New("invalid controller")
+		return
+	}
+	if in == nil || handler == nil {
+		err = errors.New("informer and handler can't be nil")
+		return
+	}
+
+	err = in.AddEventHandler(&gatedEventHandler[T]{c: ctrl, handler: handler})
+	if err != nil {
+		return
+	}
+
+	ctrl.m.Lock()
+	defer ctrl.m.Unlock()
+
+	ctrl.replays = append(ctrl.replays, func() {
+		objects, err := in.List(labels.Everything(), "")
+		if err != nil {
+			klog.Errorf("Failed to list the cache of an informer: %v", err)
+			return
+		}
+
+		for _, obj := range objects {
+			ctrl.dispatch(func() { handler.OnAdd(obj) })
+		}
+	})
+	return
+}
+
+// NewController returns a Controller that acts on the events of its informers only while it leads the election
+func NewController(options ControllerOptions) (c Controller, err error) {
+	ctrl := &controller{options: options}
+
+	runnerOptions := options.Runner
+	runnerOptions.OnStartedLeading = ctrl.lead
+	runnerOptions.OnStoppedLeading = func() {
+		ctrl.close()
+		if options.Runner.OnStoppedLeading != nil {
+			options.Runner.OnStoppedLeading()
+		}
+	}
+
+	ctrl.runner, err = NewRunner(runnerOptions)
+	if err != nil {
+		return
+	}
+
+	c = ctrl
+	return
+}
@@ -0,0 +1,284 @@
+package multi_cluster_lock
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/the-gigi/go-k8s/pkg/informer"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// fakeFactory counts how many times it was started
+type fakeFactory struct {
+	started atomic.Int32
+}
+
+func (f *fakeFactory) Start() {
+	f.started.Add(1)
+}
+
+func (f *fakeFactory) Stop() {}
+
+func (f *fakeFactory) GetBaseInformer(schema.GroupVersionResource) (informer.BaseInformer, error) {
+	return nil, nil
+}
+
+// fakeInformer is an informer.Informer with a cache of strings that it fills with add()
+type fakeInformer struct {
+	m        sync.Mutex
+	objects  []string
+	handlers []informer.EventHandler[string]
+}
+
+func (in *fakeInformer) add(obj string) {
+	in.m.Lock()
+	in.objects = append(in.objects, obj)
+	handlers := in.handlers
+	in.m.Unlock()
+
+	for _, h := range handlers {
+		h.OnAdd(obj)
+	}
+}
+
+func (in *fakeInformer) AddEventHandler(handler informer.EventHandler[string]) error {
+	in.m.Lock()
+	defer in.m.Unlock()
+
+	in.handlers = append(in.handlers, handler)
+	return nil
+}
+
+func (in *fakeInformer) List(labels.Selector, string) (objects []string, err error) {
+	in.m.Lock()
+	defer in.m.Unlock()
+
+	return append(objects, in.objects...), nil
+}
+
+func (in *fakeInformer) Get(string, *string) error {
+	return nil
+}
+
+// unavailableBackend fails every call while down is true
+type unavailableBackend struct {
+	Backend
+	down atomic.Bool
+}
+
+func (b *unavailableBackend) Get(ctx context.Context) (record []byte, version string, err error) {
+	if b.down.Load() {
+		err = errInjected
+		return
+	}
+	return b.Backend.Get(ctx)
+}
+
+func (b *unavailableBackend) Update(ctx context.Context, record []byte, version string) (newVersion string, err error) {
+	if b.down.Load() {
+		err = errInjected
+		return
+	}
+	return b.Backend.Update(ctx, record, version)
+}
+
+// recordingHandler records the objects it was told about
+type recordingHandler struct {
+	m     sync.Mutex
+	added []string
+}
+
+func (h *recordingHandler) OnAdd(obj string) {
+	h.m.Lock()
+	defer h.m.Unlock()
+
+	h.added = append(h.added, obj)
+}
+
+func (h *recordingHandler) OnUpdate(string, string) {}
+
+func (h *recordingHandler) OnDelete(string) {}
+
+func (h *recordingHandler) Added() []string {
+	h.m.Lock()
+	defer h.m.Unlock()
+
+	return append([]string{}, h.added...)
+}
+
+// callbackHandler asks the controller whether it leads from its event handler
+type callbackHandler struct {
+	isLeader func() bool
+	leading  atomic.Bool
+}
+
+func (h *callbackHandler) OnAdd(string) {
+	h.leading.Store(h.isLeader())
+}
+
+func (h *callbackHandler) OnUpdate(string, string) {}
+
+func (h *callbackHandler) OnDelete(string) {}
+
+var _ = Describe("Controller", func() {
+	var backend Backend
+	var factory *fakeFactory
+	var in *fakeInformer
+
+	runnerOptions := func(identity string) RunnerOptions {
+		return RunnerOptions{
+			Backend:        backend,
+			Identity:       identity,
+			LeaseDuration:  time.Second,
+			RenewDeadline:  600 * time.Millisecond,
+			RetryPeriod:    100 * time.Millisecond,
+			BackendTimeout: 100 * time.Millisecond,
+		}
+	}
+
+	BeforeEach(func() {
+		backend = NewMemoryBackend()
+		factory = &fakeFactory{}
+		in = &fakeInformer{}
+	})
+
+	It("should start the factory right away and dispatch events only while leading", func() {
+		// Someone else leads
+		other, err := NewRunner(runnerOptions("other"))
+		Ω(err).Should(BeNil())
+		otherCtx, stopOther := context.WithCancel(context.Background())
+		go other.Run(otherCtx)
+		Eventually(other.IsLeader).Should(BeTrue())
+
+		c, err := NewController(ControllerOptions{Factory: factory, Runner: runnerOptions("me")})
+		Ω(err).Should(BeNil())
+		handler := &recordingHandler{}
+		Ω(AddLeaderEventHandler[string](c, in, handler)).Should(Succeed())
+
+		ctx, cancel := context.WithCancel(context.Background())
+		DeferCleanup(cancel)
+		go c.Run(ctx)
+
+		Eventually(factory.started.Load).Should(Equal(int32(1)))
+		in.add("while-following")
+		Consistently(handler.Added, 300*time.Millisecond).Should(BeEmpty())
+		Ω(c.IsLeader()).Should(BeFalse())
+
+		// Take over. The cache is replayed
+		stopOther()
+		Eventually(c.IsLeader, 5*time.Second).Should(BeTrue())
+		Eventually(handler.Added).Should(Equal([]string{"while-following"}))
+		in.add("while-leading")
+		Ω(handler.Added()).Should(Equal([]string{"while-following", "while-leading"}))
+	})
+
+	It("should let event handlers call back into the controller", func() {
+		in.add("cached")
+
+		var c Controller
+		var err error
+		c, err = NewController(ControllerOptions{Runner: runnerOptions("me")})
+		Ω(err).Should(BeNil())
+		handler := &callbackHandler{isLeader: func() bool { return c.IsLeader() }}
+		Ω(AddLeaderEventHandler[string](c, in, handler)).Should(Succeed())
+
+		ctx, cancel := context.WithCancel(context.Background())
+		DeferCleanup(cancel)
+		go c.Run(ctx)
+
+		Eventually(handler.leading.Load, 5*time.Second).Should(BeTrue())
+	})
+
+	It("should release the lock only after the work stopped", func() {
+		var heldWhileStopping atomic.Bool
+		options := runnerOptions("me")
+		options.ReleaseOnCancel = true
+
+		c, err := NewController(ControllerOptions{
+			Runner: options,
+			Reconcile: func(ctx context.Context) {
+				<-ctx.Done()
+
+				// The lock is still ours while we wind down
+				time.Sleep(200 * time.Millisecond)
+				data, _, err := backend.Get(context.Background())
+				if err == nil {
+					var record lockRecord
+					if json.Unmarshal(data, &record) == nil && record.HolderIdentity == "me" {
+						heldWhileStopping.Store(true)
+					}
+				}
+			},
+		})
+		Ω(err).Should(BeNil())
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			c.Run(ctx)
+		}()
+		Eventually(c.IsLeader).Should(BeTrue())
+
+		cancel()
+		Eventually(done, 5*time.Second).Should(BeClosed())
+		Ω(heldWhileStopping.Load()).Should(BeTrue())
+
+		data, _, err := backend.Get(context.Background())
+		Ω(err).Should(BeNil())
+		var record lockRecord
+		Ω(json.Unmarshal(data, &record)).Should(Succeed())
+		Ω(record.HolderIdentity).Should(BeEmpty())
+	})
+
+	It("should run the reconcile function while leading and stop acting when the leadership is lost", func() {
+		var reconciling atomic.Bool
+		var stopped atomic.Int32
+		unavailable := &unavailableBackend{Backend: backend}
+		backend = unavailable
+		options := runnerOptions("me")
+		options.OnStoppedLeading = func() { stopped.Add(1) }
+
+		c, err := NewController(ControllerOptions{
+			Runner: options,
+			Reconcile: func(ctx context.Context) {
+				defer GinkgoRecover()
+				token, ok := FencingTokenFromContext(ctx)
+				Ω(ok).Should(BeTrue())
+				Ω(token).Should(BeNumerically(">", 0))
+
+				reconciling.Store(true)
+				<-ctx.Done()
+				reconciling.Store(false)
+			},
+		})
+		Ω(err).Should(BeNil())
+		handler := &recordingHandler{}
+		Ω(AddLeaderEventHandler[string](c, in, handler)).Should(Succeed())
+
+		ctx, cancel := context.WithCancel(context.Background())
+		DeferCleanup(cancel)
+		go c.Run(ctx)
+		Eventually(reconciling.Load).Should(BeTrue())
+
+		// The backend goes down, so we can't renew
+		unavailable.down.Store(true)
+		Eventually(reconciling.Load, 5*time.Second).Should(BeFalse())
+		Eventually(stopped.Load).Should(BeNumerically(">=", 1))
+		Ω(c.IsLeader()).Should(BeFalse())
+
+		in.add("after-losing")
+		Ω(handler.Added()).ShouldNot(ContainElement("after-losing"))
+	})
+
+	It("should only accept its own controllers", func() {
+		err := AddLeaderEventHandler[string](nil, in, &recordingHandler{})
+		Ω(err).ShouldNot(BeNil())
+	})
+})
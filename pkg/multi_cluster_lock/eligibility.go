@@ -0,0 +1,108 @@
+package multi_cluster_lock
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	pkgerrors "github.com/pkg/errors"
+	"github.com/the-gigi/go-k8s/pkg/client"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// EligibilityFunc returns true if this instance may acquire the lock, e.g. because its cluster is healthy.
+// An error makes the instance ineligible
+type EligibilityFunc func(ctx context.Context) (eligible bool, err error)
+
+// reasonNotEligible is the reason of the API error for an acquisition by an ineligible instance
+const reasonNotEligible metav1.StatusReason = "NotEligible"
+
+// IsNotEligible returns true if err reports that the lock wasn't acquired because the instance isn't eligible
+func IsNotEligible(err error) bool {
+	return errors.ReasonForError(err) == reasonNotEligible
+}
+
+// notEligible returns the error of an acquisition by an ineligible instance
+func (gl *gistLock) notEligible(cause error) error {
+	name := gl.backend.Describe()
+	message := fmt.Sprintf("%s %q: %s is not eligible to acquire the lock", qualifiedResource.String(), name, gl.identity)
+	if cause != nil {
+		message += ": " + cause.Error()
+	}
+
+	return &errors.StatusError{ErrStatus: metav1.Status{
+		Status:  metav1.StatusFailure,
+		Code:    http.StatusPreconditionFailed,
+		Reason:  reasonNotEligible,
+		Message: message,
+		Details: &metav1.StatusDetails{
+			Group: qualifiedResource.Group,
+			Kind:  qualifiedResource.Resource,
+			Name:  name,
+		},
+	}}
+}
+
+// checkEligible returns an error if the lock has an eligibility predicate that doesn't let it acquire the lock
+func (gl *gistLock) checkEligible(ctx context.Context) (err error) {
+	if gl.options.Eligible == nil {
+		return
+	}
+
+	eligible, err := gl.options.Eligible(ctx)
+	if err != nil || !eligible {
+		err = gl.notEligible(err)
+	}
+	return
+}
+
+// nodeReady returns true if the Ready condition of node is true
+func nodeReady(node *corev1.Node) bool {
+	for _, condition := range node.Status.Conditions {
+		if condition.Type == corev1.NodeReady {
+			return condition.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// NodesReady returns an EligibilityFunc that considers the cluster of cli healthy if at least
+// minReadyFraction (between 0 and 1) of its nodes, and at least one, are Ready
+func NodesReady(cli client.Clientset, minReadyFraction float64) (eligible EligibilityFunc, err error) {
+	if cli == nil {
+		err = pkgerrors.New("clientset can't be nil")
+		return
+	}
+	if minReadyFraction < 0 || minReadyFraction > 1 {
+		err = pkgerrors.New("the fraction of ready nodes must be between 0 and 1")
+		return
+	}
+
+	eligible = func(ctx context.Context) (ok bool, err error) {
+		nodes, err := cli.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+		if err != nil {
+			err = pkgerrors.Wrap(err, "failed to list the nodes")
+			return
+		}
+
+		ready := 0
+		for i := range nodes.Items {
+			if nodeReady(&nodes.Items[i]) {
+				ready++
+			}
+		}
+		if ready == 0 {
+			err = pkgerrors.New("no node is ready")
+			return
+		}
+
+		ok = float64(ready) >= minReadyFraction*float64(len(nodes.Items))
+		if !ok {
+			err = pkgerrors.Errorf("%d of %d nodes are ready", ready, len(nodes.Items))
+		}
+		return
+	}
+	return
+}
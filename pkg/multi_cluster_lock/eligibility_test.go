@@ -0,0 +1,123 @@
+package multi_cluster_lock
+
+import (
+	"context"
+	"sync/atomic"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	clocktesting "k8s.io/utils/clock/testing"
+)
+
+// newNode returns a node that is Ready if ready
+func newNode(name string, ready bool) *corev1.Node {
+	status := corev1.ConditionFalse
+	if ready {
+		status = corev1.ConditionTrue
+	}
+	return &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Status: corev1.NodeStatus{
+			Conditions: []corev1.NodeCondition{{Type: corev1.NodeReady, Status: status}},
+		},
+	}
+}
+
+var _ = Describe("Eligibility", func() {
+	ctx := context.Background()
+	var backend Backend
+	var fakeClock *clocktesting.FakePassiveClock
+	var eligible atomic.Bool
+	var probes atomic.Int32
+
+	var options LockOptions
+
+	BeforeEach(func() {
+		backend = NewMemoryBackend()
+		fakeClock = newTestClock()
+		eligible.Store(true)
+		probes.Store(0)
+		options = LockOptions{
+			Clock: fakeClock,
+			Eligible: func(context.Context) (bool, error) {
+				probes.Add(1)
+				return eligible.Load(), nil
+			},
+		}
+	})
+
+	It("should not acquire the lock while ineligible", func() {
+		me := newTestLock(backend, "me", options)
+		eligible.Store(false)
+
+		err := me.Create(ctx, newRecord("me", fakeClock.Now()))
+		Ω(IsNotEligible(err)).Should(BeTrue())
+		_, _, err = backend.Get(ctx)
+		Ω(err).Should(MatchError(ErrNotFound))
+
+		eligible.Store(true)
+		Ω(me.Create(ctx, newRecord("me", fakeClock.Now()))).Should(Succeed())
+	})
+
+	It("should not take over an expired lease while ineligible but keep renewing its own", func() {
+		Ω(newTestLock(backend, "other", options).Create(ctx, newRecord("other", fakeClock.Now()))).Should(Succeed())
+		me := newTestLock(backend, "me", options)
+		expireLease(me, fakeClock)
+
+		eligible.Store(false)
+		Ω(IsNotEligible(me.Update(ctx, newRecord("me", fakeClock.Now())))).Should(BeTrue())
+
+		eligible.Store(true)
+		Ω(me.Update(ctx, newRecord("me", fakeClock.Now()))).Should(Succeed())
+
+		// The holder isn't asked again
+		eligible.Store(false)
+		probes.Store(0)
+		Ω(me.Update(ctx, newRecord("me", fakeClock.Now()))).Should(Succeed())
+		Ω(probes.Load()).Should(BeZero())
+	})
+
+	It("should not probe when the lease is still valid", func() {
+		Ω(newTestLock(backend, "other", options).Create(ctx, newRecord("other", fakeClock.Now()))).Should(Succeed())
+		probes.Store(0)
+
+		err := newTestLock(backend, "me", options).Update(ctx, newRecord("me", fakeClock.Now()))
+		Ω(err).ShouldNot(BeNil())
+		Ω(IsNotEligible(err)).Should(BeFalse())
+		Ω(probes.Load()).Should(BeZero())
+	})
+
+	Context("NodesReady", func() {
+		probe := func(objects ...runtime.Object) (bool, error) {
+			f, err := NodesReady(fake.NewSimpleClientset(objects...), 0.5)
+			Ω(err).Should(BeNil())
+			return f(ctx)
+		}
+
+		It("should consider a cluster with enough ready nodes healthy", func() {
+			ok, err := probe(newNode("a", true), newNode("b", true), newNode("c", false))
+			Ω(err).Should(BeNil())
+			Ω(ok).Should(BeTrue())
+		})
+
+		It("should consider a cluster with too few ready nodes unhealthy", func() {
+			ok, err := probe(newNode("a", true), newNode("b", false), newNode("c", false))
+			Ω(err).Should(MatchError(ContainSubstring("1 of 3 nodes are ready")))
+			Ω(ok).Should(BeFalse())
+
+			ok, _ = probe()
+			Ω(ok).Should(BeFalse())
+		})
+
+		It("should validate its arguments", func() {
+			_, err := NodesReady(nil, 0.5)
+			Ω(err).ShouldNot(BeNil())
+			_, err = NodesReady(fake.NewSimpleClientset(), 1.5)
+			Ω(err).ShouldNot(BeNil())
+		})
+	})
+})
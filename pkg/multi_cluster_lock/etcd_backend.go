@@ -0,0 +1,190 @@
+package multi_cluster_lock
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+// etcdBackend keeps the lock record under a key of an etcd cluster.
+//
+// The version of the record is the mod revision of the key. Writes are transactions
+// that compare the mod revision (or that the key doesn't exist yet) before putting
+// the record, so acquire and renew are atomic.
+//
+// The backend is an Expirer: UpdateWithTTL attaches the key to an etcd lease with the TTL
+// the lock passes (the lease duration of the record). The backend keeps its etcd lease
+// and refreshes it with KeepAliveOnce on every renewal, and revokes a lease it granted
+// for a write that failed. If the leader stops renewing, etcd deletes the key when the
+// lease duration is over and the next contender can create it right away. Since the
+// record (and its fencing token) is gone by then, the backend implements Sequencer with
+// the revision of the etcd cluster, which is at least as large as any token written so far.
+type etcdBackend struct {
+	cli *clientv3.Client
+	key string
+
+	// The etcd lease our last write attached the key to and its TTL in seconds
+	m        sync.Mutex
+	lease    clientv3.LeaseID
+	leaseTTL int64
+}
+
+func (b *etcdBackend) Get(ctx context.Context) (record []byte, version string, err error) {
+	resp, err := b.cli.Get(ctx, b.key)
+	if err != nil {
+		return
+	}
+
+	if len(resp.Kvs) == 0 {
+		err = ErrNotFound
+		return
+	}
+
+	kv := resp.Kvs[0]
+	record = kv.Value
+	version = strconv.FormatInt(kv.ModRevision, 10)
+	return
+}
+
+// grant returns an etcd lease with a TTL of seconds. It refreshes the lease of our last write if it has the
+// same TTL and is still alive, and grants a new one otherwise (granted is true then)
+func (b *etcdBackend) grant(ctx context.Context, seconds int64) (lease clientv3.LeaseID, granted bool, err error) {
+	b.m.Lock()
+	lease, leaseTTL := b.lease, b.leaseTTL
+	b.m.Unlock()
+
+	if lease != clientv3.NoLease && leaseTTL == seconds {
+		_, err = b.cli.KeepAliveOnce(ctx, lease)
+		if !errors.Is(err, rpctypes.ErrLeaseNotFound) {
+			return
+		}
+	}
+
+	resp, err := b.cli.Grant(ctx, seconds)
+	if err != nil {
+		return
+	}
+
+	lease = resp.ID
+	granted = true
+	return
+}
+
+// revoke revokes lease in the background of ctx, since ctx may be done already.
+// It is best effort: a lease that isn't revoked expires after its TTL.
+func (b *etcdBackend) revoke(ctx context.Context, lease clientv3.LeaseID) {
+	if lease == clientv3.NoLease {
+		return
+	}
+
+	revokeCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), defaultBackendTimeout)
+	defer cancel()
+	_, _ = b.cli.Revoke(revokeCtx, lease)
+}
+
+func (b *etcdBackend) Update(ctx context.Context, record []byte, version string) (newVersion string, err error) {
+	return b.UpdateWithTTL(ctx, record, version, 0)
+}
+
+// UpdateWithTTL writes the record like Update and attaches the key to an etcd lease with ttl (rounded up to seconds).
+// Without a positive ttl the key doesn't expire.
+func (b *etcdBackend) UpdateWithTTL(ctx context.Context, record []byte, version string, ttl time.Duration) (newVersion string, err error) {
+	cmp := clientv3.Compare(clientv3.CreateRevision(b.key), "=", 0)
+	if version != "" {
+		var modRevision int64
+		modRevision, err = strconv.ParseInt(version, 10, 64)
+		if err != nil {
+			err = ErrConflict
+			return
+		}
+		cmp = clientv3.Compare(clientv3.ModRevision(b.key), "=", modRevision)
+	}
+
+	var opts []clientv3.OpOption
+	var lease clientv3.LeaseID
+	var granted bool
+	seconds := int64((ttl + time.Second - 1) / time.Second)
+	if seconds > 0 {
+		lease, granted, err = b.grant(ctx, seconds)
+		if err != nil {
+			return
+		}
+		opts = append(opts, clientv3.WithLease(lease))
+	}
+
+	resp, err := b.cli.Txn(ctx).
+		If(cmp).
+		Then(clientv3.OpPut(b.key, string(record), opts...)).
+		Commit()
+	if err == nil && !resp.Succeeded {
+		err = ErrConflict
+	}
+	if err != nil {
+		if granted {
+			b.revoke(ctx, lease)
+		}
+		return
+	}
+
+	// The key moved off the lease of our previous write, if it was a different one
+	b.m.Lock()
+	old := b.lease
+	b.lease, b.leaseTTL = lease, seconds
+	b.m.Unlock()
+	if old != lease {
+		b.revoke(ctx, old)
+	}
+
+	newVersion = strconv.FormatInt(resp.Header.Revision, 10)
+	return
+}
+
+// Sequence returns the current revision of the etcd cluster
+func (b *etcdBackend) Sequence(ctx context.Context) (seq int64, err error) {
+	resp, err := b.cli.Get(ctx, b.key, clientv3.WithCountOnly())
+	if err != nil {
+		return
+	}
+
+	seq = resp.Header.Revision
+	return
+}
+
+func (b *etcdBackend) Describe() string {
+	return "etcd key " + b.key
+}
+
+// NewEtcdBackend returns a backend that keeps the lock record under key in the etcd cluster cli talks to
+func NewEtcdBackend(cli *clientv3.Client, key string) (backend Backend, err error) {
+	if cli == nil {
+		err = errors.New("etcd client can't be nil")
+		return
+	}
+
+	if key == "" {
+		err = errors.New("key can't be empty")
+		return
+	}
+
+	backend = &etcdBackend{
+		cli: cli,
+		key: key,
+	}
+	return
+}
+
+// NewEtcdLock returns a multi-cluster lock that keeps its record under key in the etcd cluster cli talks to
+func NewEtcdLock(identity string, cli *clientv3.Client, key string) (lock resourcelock.Interface, err error) {
+	backend, err := NewEtcdBackend(cli, key)
+	if err != nil {
+		return
+	}
+
+	return NewLock(identity, backend)
+}
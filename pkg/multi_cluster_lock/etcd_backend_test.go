@@ -0,0 +1,174 @@
+package multi_cluster_lock
+
+import (
+	"context"
+	"net/url"
+	"os"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/server/v3/embed"
+	"k8s.io/apimachinery/pkg/util/uuid"
+)
+
+// startEtcd starts an embedded single node etcd server on random local ports
+func startEtcd() (cli *clientv3.Client) {
+	dir, err := os.MkdirTemp("", "multi-cluster-lock-etcd")
+	Ω(err).Should(BeNil())
+	DeferCleanup(os.RemoveAll, dir)
+
+	clientURL, _ := url.Parse("http://127.0.0.1:0")
+	peerURL, _ := url.Parse("http://127.0.0.1:0")
+
+	cfg := embed.NewConfig()
+	cfg.Dir = dir
+	cfg.LogLevel = "error"
+	cfg.ListenClientUrls = []url.URL{*clientURL}
+	cfg.AdvertiseClientUrls = []url.URL{*clientURL}
+	cfg.ListenPeerUrls = []url.URL{*peerURL}
+	cfg.AdvertisePeerUrls = []url.URL{*peerURL}
+	cfg.InitialCluster = cfg.Name + "=" + peerURL.String()
+
+	e, err := embed.StartEtcd(cfg)
+	Ω(err).Should(BeNil())
+	DeferCleanup(e.Close)
+
+	select {
+	case <-e.Server.ReadyNotify():
+	case <-time.After(30 * time.Second):
+		Fail("etcd didn't start in time")
+	}
+
+	cli, err = clientv3.New(clientv3.Config{
+		Endpoints:   []string{e.Clients[0].Addr().String()},
+		DialTimeout: 5 * time.Second,
+	})
+	Ω(err).Should(BeNil())
+	DeferCleanup(cli.Close)
+	return
+}
+
+var _ = Describe("etcd backend", Ordered, func() {
+	ctx := context.Background()
+	var cli *clientv3.Client
+
+	BeforeAll(func() {
+		cli = startEtcd()
+	})
+
+	Context("backend contract", func() {
+		testBackendContract(func() Backend {
+			backend, err := NewEtcdBackend(cli, "/locks/"+string(uuid.NewUUID()))
+			Ω(err).Should(BeNil())
+			return backend
+		})
+	})
+
+	It("should validate its arguments", func() {
+		_, err := NewEtcdBackend(nil, "/locks/my-workload")
+		Ω(err).ShouldNot(BeNil())
+
+		_, err = NewEtcdBackend(cli, "")
+		Ω(err).ShouldNot(BeNil())
+	})
+
+	It("should expire the record after the lease duration", func() {
+		key := "/locks/" + string(uuid.NewUUID())
+		lock, err := NewEtcdLock("me", cli, key)
+		Ω(err).Should(BeNil())
+
+		err = lock.Create(ctx, newRecord("me", time.Now()))
+		Ω(err).Should(BeNil())
+		token := lock.(FencingLock).FencingToken()
+
+		resp, err := cli.Get(ctx, key)
+		Ω(err).Should(BeNil())
+		Ω(resp.Kvs).Should(HaveLen(1))
+		Ω(resp.Kvs[0].Lease).ShouldNot(BeZero())
+
+		Eventually(func() int {
+			resp, err := cli.Get(ctx, key)
+			Ω(err).Should(BeNil())
+			return len(resp.Kvs)
+		}, 10*time.Second, 100*time.Millisecond).Should(BeZero())
+
+		// The next contender can create the record right away
+		other, err := NewEtcdLock("other", cli, key)
+		Ω(err).Should(BeNil())
+		err = other.Create(ctx, newRecord("other", time.Now()))
+		Ω(err).Should(BeNil())
+
+		// Its fencing token is still higher, although the old record is gone
+		Ω(other.(FencingLock).FencingToken()).Should(BeNumerically(">", token))
+	})
+
+	It("should keep its etcd lease while the record is renewed", func() {
+		key := "/locks/" + string(uuid.NewUUID())
+		lock, err := NewEtcdLock("me", cli, key)
+		Ω(err).Should(BeNil())
+
+		leaseOf := func() clientv3.LeaseID {
+			resp, err := cli.Get(ctx, key)
+			Ω(err).Should(BeNil())
+			Ω(resp.Kvs).Should(HaveLen(1))
+			return clientv3.LeaseID(resp.Kvs[0].Lease)
+		}
+
+		ler := newRecord("me", time.Now())
+		ler.LeaseDurationSeconds = 10
+		Ω(lock.Create(ctx, ler)).Should(Succeed())
+		lease := leaseOf()
+
+		ler.RenewTime.Time = time.Now()
+		Ω(lock.Update(ctx, ler)).Should(Succeed())
+		Ω(leaseOf()).Should(Equal(lease))
+
+		// A different lease duration needs a new etcd lease, and the old one is revoked
+		ler.LeaseDurationSeconds = 20
+		Ω(lock.Update(ctx, ler)).Should(Succeed())
+		Ω(leaseOf()).ShouldNot(Equal(lease))
+		ttl, err := cli.TimeToLive(ctx, lease)
+		Ω(err).Should(BeNil())
+		Ω(ttl.TTL).Should(Equal(int64(-1)))
+	})
+
+	It("should revoke the etcd lease of a failed write", func() {
+		backend, err := NewEtcdBackend(cli, "/locks/"+string(uuid.NewUUID()))
+		Ω(err).Should(BeNil())
+		before, err := cli.Leases(ctx)
+		Ω(err).Should(BeNil())
+
+		_, err = backend.(Expirer).UpdateWithTTL(ctx, []byte("record"), "42", 10*time.Second)
+		Ω(err).Should(MatchError(ErrConflict))
+
+		after, err := cli.Leases(ctx)
+		Ω(err).Should(BeNil())
+		Ω(after.Leases).Should(HaveLen(len(before.Leases)))
+	})
+
+	It("should expire sealed and semaphore records, which it can't read", func() {
+		hasLease := func(key string) bool {
+			resp, err := cli.Get(ctx, key)
+			Ω(err).Should(BeNil())
+			Ω(resp.Kvs).Should(HaveLen(1))
+			return resp.Kvs[0].Lease != 0
+		}
+
+		sealedKey := "/locks/" + string(uuid.NewUUID())
+		backend, err := NewEtcdBackend(cli, sealedKey)
+		Ω(err).Should(BeNil())
+		lock := newTestLock(backend, "me", LockOptions{Secret: []byte("0123456789abcdef0123456789abcdef"), Encrypt: true})
+		Ω(lock.Create(ctx, newRecord("me", time.Now()))).Should(Succeed())
+		Ω(hasLease(sealedKey)).Should(BeTrue())
+
+		semaphoreKey := "/semaphores/" + string(uuid.NewUUID())
+		backend, err = NewEtcdBackend(cli, semaphoreKey)
+		Ω(err).Should(BeNil())
+		sem, err := NewSemaphore("me", backend, SemaphoreOptions{Limit: 2})
+		Ω(err).Should(BeNil())
+		Ω(sem.TryAcquire(ctx)).Should(BeTrue())
+		Ω(hasLease(semaphoreKey)).Should(BeTrue())
+	})
+})
@@ -0,0 +1,60 @@
+package multi_cluster_lock
+
+import (
+	"github.com/the-gigi/go-k8s/pkg/client"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"k8s.io/client-go/tools/record"
+)
+
+const (
+	defaultEventNamespace = "default"
+	defaultEventName      = "multi-cluster-lock"
+)
+
+// eventSubject returns the object the events of a lock refer to.
+//
+// Like the built-in LeaseLock, the events are attached to a Lease. The Lease doesn't have to exist
+// in the member cluster; it just groups the events of the lock, e.g. for kubectl describe.
+func eventSubject(namespace string, name string) *coordinationv1.Lease {
+	subject := &coordinationv1.Lease{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: namespace,
+			Name:      name,
+		},
+	}
+	// Populate the type meta, so the recorder doesn't have to get it from the scheme
+	subject.Kind = "Lease"
+	subject.APIVersion = coordinationv1.SchemeGroupVersion.String()
+	return subject
+}
+
+// NewEventRecorderWithClientset returns an EventRecorder that emits Kubernetes Events in the cluster of cli.
+//
+// Call shutdown when done to stop the event broadcaster.
+func NewEventRecorderWithClientset(cli client.Clientset, component string) (recorder resourcelock.EventRecorder, shutdown func()) {
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: cli.CoreV1().Events("")})
+
+	recorder = broadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: component})
+	shutdown = broadcaster.Shutdown
+	return
+}
+
+// NewEventRecorder returns an EventRecorder that emits Kubernetes Events in the cluster of kubeContext.
+//
+// Each instance typically records events in the member cluster it runs in.
+// Call shutdown when done to stop the event broadcaster.
+func NewEventRecorder(kubeConfigPath string, kubeContext string, component string) (recorder resourcelock.EventRecorder, shutdown func(), err error) {
+	cli, err := client.NewClientset(kubeConfigPath, kubeContext)
+	if err != nil {
+		return
+	}
+
+	recorder, shutdown = NewEventRecorderWithClientset(cli, component)
+	return
+}
@@ -0,0 +1,58 @@
+package multi_cluster_lock
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+)
+
+var _ = Describe("Events", func() {
+	It("should not record events without a recorder", func() {
+		lock, err := NewLock("me", NewMemoryBackend())
+		Ω(err).Should(BeNil())
+		lock.RecordEvent("became leader")
+	})
+
+	It("should record events with the recorder", func() {
+		recorder := record.NewFakeRecorder(10)
+		lock, err := NewLockWithOptions("me", NewMemoryBackend(), LockOptions{EventRecorder: recorder})
+		Ω(err).Should(BeNil())
+
+		lock.RecordEvent("became leader")
+		Ω(recorder.Events).Should(Receive(Equal("Normal LeaderElection me became leader (In-memory)")))
+	})
+
+	It("should emit Kubernetes events in the member cluster", func() {
+		cli := fake.NewSimpleClientset()
+		recorder, shutdown := NewEventRecorderWithClientset(cli, "my-workload")
+		DeferCleanup(shutdown)
+
+		lock, err := NewLockWithOptions("me", NewMemoryBackend(), LockOptions{
+			EventRecorder:  recorder,
+			EventNamespace: "workloads",
+			EventName:      "my-workload",
+		})
+		Ω(err).Should(BeNil())
+		lock.RecordEvent("became leader")
+
+		Eventually(func() int {
+			events, err := cli.CoreV1().Events("workloads").List(context.Background(), metav1.ListOptions{})
+			Ω(err).Should(BeNil())
+			return len(events.Items)
+		}, 5*time.Second).Should(Equal(1))
+
+		events, err := cli.CoreV1().Events("workloads").List(context.Background(), metav1.ListOptions{})
+		Ω(err).Should(BeNil())
+		event := events.Items[0]
+		Ω(event.InvolvedObject.Kind).Should(Equal("Lease"))
+		Ω(event.InvolvedObject.Name).Should(Equal("my-workload"))
+		Ω(event.Reason).Should(Equal("LeaderElection"))
+		Ω(event.Message).Should(Equal("me became leader (In-memory)"))
+		Ω(event.Source.Component).Should(Equal("my-workload"))
+	})
+})
@@ -0,0 +1,194 @@
+package multi_cluster_lock
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+)
+
+// FakeGistServer is a tiny in-memory implementation of the parts of the Gist API the GistClient uses.
+//
+// It supports multiple files per gist, revisions and can inject error responses. Like api.github.com,
+// it ignores If-Match unless HonorIfMatch is set. Use it in tests together with a GistClient created by NewClient().
+type FakeGistServer struct {
+	*httptest.Server
+	HonorIfMatch bool // if true, writes with a stale If-Match fail with 412 Precondition Failed, unlike on api.github.com
+
+	m        sync.Mutex
+	history  map[string][]fakeRevision // newest last
+	revision int
+	failures []fakeFailure
+}
+
+// fakeRevision is a snapshot of all the files of a gist
+type fakeRevision struct {
+	version string
+	files   map[string]string
+}
+
+// fakeFailure is a canned error response the server returns instead of handling a request
+type fakeFailure struct {
+	status int
+	header http.Header
+	body   string
+}
+
+// Fail makes the server respond to the next request with status and header
+func (s *FakeGistServer) Fail(status int, header http.Header) {
+	s.FailWith(status, header, `{"message": "fake failure"}`)
+}
+
+// FailWith makes the server respond to the next request with status, header and body
+func (s *FakeGistServer) FailWith(status int, header http.Header, body string) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	s.failures = append(s.failures, fakeFailure{status: status, header: header, body: body})
+}
+
+func (s *FakeGistServer) latest(id string) fakeRevision {
+	h := s.history[id]
+	return h[len(h)-1]
+}
+
+// commit records a new revision of the gist with files
+func (s *FakeGistServer) commit(id string, files map[string]string) {
+	s.revision++
+	s.history[id] = append(s.history[id], fakeRevision{
+		version: fmt.Sprintf("rev-%d", s.revision),
+		files:   files,
+	})
+}
+
+// gist renders the gist at the revision with index i as a Gist API object
+func (s *FakeGistServer) gist(id string, i int) map[string]any {
+	files := map[string]any{}
+	for name, content := range s.history[id][i].files {
+		files[name] = map[string]any{"filename": name, "content": content}
+	}
+
+	var history []any
+	for j := i; j >= 0; j-- {
+		history = append(history, map[string]any{"version": s.history[id][j].version})
+	}
+
+	return map[string]any{"id": id, "files": files, "history": history}
+}
+
+func (s *FakeGistServer) etag(id string) string {
+	return `"` + s.latest(id).version + `"`
+}
+
+// Put stores content in a gist file and records a new revision. The gist is created if needed
+func (s *FakeGistServer) Put(id string, filename string, content string) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	files := map[string]string{}
+	if len(s.history[id]) > 0 {
+		files = maps.Clone(s.latest(id).files)
+	}
+	files[filename] = content
+	s.commit(id, files)
+}
+
+func (s *FakeGistServer) handle(w http.ResponseWriter, r *http.Request) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if len(s.failures) > 0 {
+		failure := s.failures[0]
+		s.failures = s.failures[1:]
+		for k, v := range failure.header {
+			w.Header()[k] = v
+		}
+		w.WriteHeader(failure.status)
+		_, _ = w.Write([]byte(failure.body))
+		return
+	}
+
+	// The path is /gists/{id} or /gists/{id}/{revision}
+	id, revision, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/gists/"), "/")
+	if len(s.history[id]) == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte(`{"message": "Not Found"}`))
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		if revision != "" {
+			for i, rev := range s.history[id] {
+				if rev.version == revision {
+					_ = json.NewEncoder(w).Encode(s.gist(id, i))
+					return
+				}
+			}
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"message": "Not Found"}`))
+			return
+		}
+	case http.MethodPatch:
+		ifMatch := r.Header.Get("If-Match")
+		if ifMatch != "" && ifMatch != s.etag(id) && s.HonorIfMatch {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return
+		}
+
+		body, _ := io.ReadAll(r.Body)
+		var patch struct {
+			Files map[string]struct {
+				Content string `json:"content"`
+			} `json:"files"`
+		}
+		err := json.Unmarshal(body, &patch)
+		if err != nil {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			return
+		}
+
+		files := maps.Clone(s.latest(id).files)
+		for name, file := range patch.Files {
+			files[name] = file.Content
+		}
+		s.commit(id, files)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("ETag", s.etag(id))
+	_ = json.NewEncoder(w).Encode(s.gist(id, len(s.history[id])-1))
+}
+
+// Content returns the current content of a gist file
+func (s *FakeGistServer) Content(id string, filename string) (content string) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if len(s.history[id]) == 0 {
+		return
+	}
+	return s.latest(id).files[filename]
+}
+
+// NewClient returns a GistClient that talks to the fake server
+func (s *FakeGistServer) NewClient(accessToken string) (gc *GistClient) {
+	// The URL of the test server is always valid
+	gc, _ = NewGistClientWithOptions(accessToken, GistClientOptions{BaseURL: s.URL})
+	return
+}
+
+// NewFakeGistServer starts a fake Gist API server. Call Close() when done.
+func NewFakeGistServer() (s *FakeGistServer) {
+	s = &FakeGistServer{
+		history: map[string][]fakeRevision{},
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	return
+}
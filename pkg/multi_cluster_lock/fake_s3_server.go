@@ -0,0 +1,109 @@
+package multi_cluster_lock
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+)
+
+// FakeS3Server is a tiny in-memory implementation of the parts of the S3 API the S3 backend uses.
+//
+// It supports conditional PUTs with If-Match and If-None-Match and checks that requests are
+// signed with the expected access key. Use it in tests together with the options from Options().
+type FakeS3Server struct {
+	*httptest.Server
+	accessKeyID string
+
+	m       sync.Mutex
+	objects map[string][]byte // by bucket/key
+}
+
+func etagOf(data []byte) string {
+	sum := md5.Sum(data)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+func writeS3Error(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	_, _ = fmt.Fprintf(w, "<Error><Code>%s</Code><Message>%s</Message></Error>", code, http.StatusText(status))
+}
+
+func (s *FakeS3Server) handle(w http.ResponseWriter, r *http.Request) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential="+s.accessKeyID+"/") {
+		writeS3Error(w, http.StatusForbidden, "AccessDenied")
+		return
+	}
+
+	name := strings.TrimPrefix(r.URL.Path, "/")
+	data, exists := s.objects[name]
+	switch r.Method {
+	case http.MethodGet:
+		if !exists {
+			writeS3Error(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		w.Header().Set("ETag", etagOf(data))
+		_, _ = w.Write(data)
+	case http.MethodPut:
+		if r.Header.Get("If-None-Match") == "*" && exists {
+			writeS3Error(w, http.StatusPreconditionFailed, "PreconditionFailed")
+			return
+		}
+
+		ifMatch := r.Header.Get("If-Match")
+		if ifMatch != "" && !exists {
+			writeS3Error(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		if ifMatch != "" && ifMatch != etagOf(data) {
+			writeS3Error(w, http.StatusPreconditionFailed, "PreconditionFailed")
+			return
+		}
+
+		body, _ := io.ReadAll(r.Body)
+		s.objects[name] = body
+		w.Header().Set("ETag", etagOf(body))
+	default:
+		writeS3Error(w, http.StatusMethodNotAllowed, "MethodNotAllowed")
+	}
+}
+
+// Object returns the content of an object
+func (s *FakeS3Server) Object(bucket string, key string) (data []byte, ok bool) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	data, ok = s.objects[bucket+"/"+key]
+	return
+}
+
+// Options returns the options of an S3 backend that keeps its record in bucket/key on the fake server
+func (s *FakeS3Server) Options(bucket string, key string) S3Options {
+	return S3Options{
+		Endpoint:        s.URL,
+		Region:          "us-east-1",
+		Bucket:          bucket,
+		Key:             key,
+		AccessKeyID:     s.accessKeyID,
+		SecretAccessKey: "fake-secret",
+	}
+}
+
+// NewFakeS3Server starts a fake S3 server. Call Close() when done.
+func NewFakeS3Server() (s *FakeS3Server) {
+	s = &FakeS3Server{
+		accessKeyID: "fake-access-key",
+		objects:     map[string][]byte{},
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	return
+}
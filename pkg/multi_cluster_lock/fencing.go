@@ -0,0 +1,61 @@
+package multi_cluster_lock
+
+import (
+	"context"
+
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+// lockRecord is what the multi-cluster locks keep in the backend: the leader election record,
+// a fencing token and optionally the history of transitions (see history.go).
+//
+// The fencing token increases every time a new holder acquires the lock. Downstream systems can remember
+// the highest token they have seen and reject writes with a lower one, so a leader that lost the lock
+// (e.g. because it couldn't renew during a Github outage) can't clobber the work of the new leader.
+type lockRecord struct {
+	resourcelock.LeaderElectionRecord
+	FencingToken int64           `json:"fencingToken,omitempty"`
+	History      []Transition    `json:"history,omitempty"`
+	Metadata     *HolderMetadata `json:"metadata,omitempty"`
+	Contender    *contender      `json:"contender,omitempty"`
+}
+
+// nextFencingToken returns the fencing token of a record written by holder on top of old.
+//
+// Renewals keep the token. Releasing the lock (empty holder) keeps the token too,
+// so the next holder gets a higher one.
+func nextFencingToken(old lockRecord, holder string) int64 {
+	if holder == "" || holder == old.HolderIdentity {
+		return old.FencingToken
+	}
+	return old.FencingToken + 1
+}
+
+// Sequencer is an optional interface of backends that may lose the record, e.g. when it expires.
+//
+// Sequence returns a number that is at least as large as all the fencing tokens issued with the backend so far.
+// The lock uses it to keep fencing tokens increasing when it creates a new record.
+type Sequencer interface {
+	Sequence(ctx context.Context) (seq int64, err error)
+}
+
+// FencingLock is a multi-cluster lock that issues fencing tokens.
+// The locks returned by NewLock() implement it.
+type FencingLock interface {
+	resourcelock.Interface
+
+	// FencingToken returns the fencing token of our current leadership or 0 if we don't hold the lock
+	FencingToken() int64
+}
+
+type fencingTokenKey struct{}
+
+func withFencingToken(ctx context.Context, token int64) context.Context {
+	return context.WithValue(ctx, fencingTokenKey{}, token)
+}
+
+// FencingTokenFromContext returns the fencing token from the context passed to OnStartedLeading by a Runner
+func FencingTokenFromContext(ctx context.Context) (token int64, ok bool) {
+	token, ok = ctx.Value(fencingTokenKey{}).(int64)
+	return
+}
@@ -0,0 +1,138 @@
+package multi_cluster_lock
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	clocktesting "k8s.io/utils/clock/testing"
+)
+
+// sequencedBackend is a backend whose Sequencer reports a fixed sequence
+type sequencedBackend struct {
+	Backend
+	seq int64
+}
+
+func (b *sequencedBackend) Sequence(context.Context) (seq int64, err error) {
+	seq = b.seq
+	return
+}
+
+var _ = Describe("Fencing tokens", func() {
+	ctx := context.Background()
+	var backend Backend
+	var fakeClock *clocktesting.FakePassiveClock
+	var me, other FencingLock
+
+	storedToken := func() int64 {
+		data, _, err := backend.Get(ctx)
+		Ω(err).Should(BeNil())
+		var lr lockRecord
+		err = json.Unmarshal(data, &lr)
+		Ω(err).Should(BeNil())
+		return lr.FencingToken
+	}
+
+	BeforeEach(func() {
+		backend = NewMemoryBackend()
+		fakeClock = newTestClock()
+		me = newTestLock(backend, "me", LockOptions{Clock: fakeClock})
+		other = newTestLock(backend, "other", LockOptions{Clock: fakeClock})
+	})
+
+	It("should issue the first token when the record is created", func() {
+		Ω(me.FencingToken()).Should(BeZero())
+
+		err := me.Create(ctx, newRecord("me", time.Now()))
+		Ω(err).Should(BeNil())
+		Ω(me.FencingToken()).Should(Equal(int64(1)))
+		Ω(storedToken()).Should(Equal(int64(1)))
+	})
+
+	It("should keep the token on renewal and increase it on every acquisition", func() {
+		err := me.Create(ctx, newRecord("me", fakeClock.Now()))
+		Ω(err).Should(BeNil())
+
+		err = me.Update(ctx, newRecord("me", fakeClock.Now()))
+		Ω(err).Should(BeNil())
+		Ω(me.FencingToken()).Should(Equal(int64(1)))
+
+		expireLease(other, fakeClock)
+		err = other.Update(ctx, newRecord("other", fakeClock.Now()))
+		Ω(err).Should(BeNil())
+		Ω(other.FencingToken()).Should(Equal(int64(2)))
+
+		expireLease(me, fakeClock)
+		err = me.Update(ctx, newRecord("me", fakeClock.Now()))
+		Ω(err).Should(BeNil())
+		Ω(me.FencingToken()).Should(Equal(int64(3)))
+		Ω(storedToken()).Should(Equal(int64(3)))
+	})
+
+	It("should keep the token of a released lock for the next holder to increase", func() {
+		Ω(nextFencingToken(lockRecord{LeaderElectionRecord: newRecord("me", time.Now()), FencingToken: 1}, "")).Should(Equal(int64(1)))
+
+		err := me.Create(ctx, newRecord("me", time.Now()))
+		Ω(err).Should(BeNil())
+		err = me.Update(ctx, newRecord("", time.Now()))
+		Ω(err).Should(BeNil())
+		Ω(me.FencingToken()).Should(BeZero())
+		Ω(storedToken()).Should(Equal(int64(1)))
+
+		err = other.Update(ctx, newRecord("other", time.Now()))
+		Ω(err).Should(BeNil())
+		Ω(other.FencingToken()).Should(Equal(int64(2)))
+	})
+
+	It("should continue the sequence of the backend when the record is created", func() {
+		backend = &sequencedBackend{Backend: NewMemoryBackend(), seq: 41}
+		me = newTestLock(backend, "me", LockOptions{Clock: fakeClock})
+
+		err := me.Create(ctx, newRecord("me", time.Now()))
+		Ω(err).Should(BeNil())
+		Ω(me.FencingToken()).Should(Equal(int64(42)))
+	})
+
+	It("should be compatible with records without a token", func() {
+		data, err := json.Marshal(newRecord("me", fakeClock.Now()))
+		Ω(err).Should(BeNil())
+		_, err = backend.Update(ctx, data, "")
+		Ω(err).Should(BeNil())
+
+		expireLease(other, fakeClock)
+		err = other.Update(ctx, newRecord("other", time.Now()))
+		Ω(err).Should(BeNil())
+		Ω(other.FencingToken()).Should(Equal(int64(1)))
+	})
+
+	It("should pass the token to OnStartedLeading", func() {
+		err := other.Create(ctx, newRecord("other", time.Now().Add(-time.Minute)))
+		Ω(err).Should(BeNil())
+
+		tokens := make(chan int64, 1)
+		r, err := NewRunner(RunnerOptions{
+			Backend:        backend,
+			Identity:       "me",
+			LeaseDuration:  time.Second,
+			RenewDeadline:  600 * time.Millisecond,
+			RetryPeriod:    100 * time.Millisecond,
+			BackendTimeout: 100 * time.Millisecond,
+			OnStartedLeading: func(ctx context.Context) {
+				token, ok := FencingTokenFromContext(ctx)
+				Ω(ok).Should(BeTrue())
+				tokens <- token
+			},
+		})
+		Ω(err).Should(BeNil())
+
+		runCtx, cancel := context.WithCancel(ctx)
+		DeferCleanup(cancel)
+		go r.Run(runCtx)
+
+		Eventually(tokens, 5*time.Second).Should(Receive(Equal(int64(2))))
+		Ω(r.FencingToken()).Should(Equal(int64(2)))
+	})
+})
@@ -0,0 +1,139 @@
+//go:build unix
+
+package multi_cluster_lock
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// fileBackend keeps the lock record in a local file.
+//
+// Processes on the same machine (or sharing a file system that supports flock) can use it
+// to run leader election without any remote storage. Every read-compare-write is serialized
+// with an exclusive flock on a sidecar lock file. The version of the record is the SHA-256
+// of its content.
+type fileBackend struct {
+	filename string
+}
+
+func fileVersion(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// lock takes an exclusive flock on the sidecar lock file and returns a function that releases it
+func (b *fileBackend) lock() (unlock func(), err error) {
+	f, err := os.OpenFile(b.filename+".lock", os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return
+	}
+
+	err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX)
+	if err != nil {
+		_ = f.Close()
+		return
+	}
+
+	unlock = func() {
+		_ = syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+		_ = f.Close()
+	}
+	return
+}
+
+func (b *fileBackend) read() (record []byte, version string, err error) {
+	record, err = os.ReadFile(b.filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			err = ErrNotFound
+		}
+		return
+	}
+
+	version = fileVersion(record)
+	return
+}
+
+func (b *fileBackend) Get(ctx context.Context) (record []byte, version string, err error) {
+	unlock, err := b.lock()
+	if err != nil {
+		return
+	}
+	defer unlock()
+
+	return b.read()
+}
+
+func (b *fileBackend) Update(ctx context.Context, record []byte, version string) (newVersion string, err error) {
+	unlock, err := b.lock()
+	if err != nil {
+		return
+	}
+	defer unlock()
+
+	_, curVersion, err := b.read()
+	if err != nil && err != ErrNotFound {
+		return
+	}
+	err = nil
+
+	if version != curVersion {
+		err = ErrConflict
+		return
+	}
+
+	// Write to a temp file and rename, so readers never observe a partial record
+	tmp, err := os.CreateTemp(filepath.Dir(b.filename), filepath.Base(b.filename)+".tmp-*")
+	if err != nil {
+		return
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(record)
+	if err != nil {
+		_ = tmp.Close()
+		return
+	}
+
+	err = tmp.Close()
+	if err != nil {
+		return
+	}
+
+	err = os.Rename(tmp.Name(), b.filename)
+	if err != nil {
+		return
+	}
+
+	newVersion = fileVersion(record)
+	return
+}
+
+func (b *fileBackend) Describe() string {
+	return "File " + b.filename
+}
+
+// NewFileBackend returns a backend that keeps the lock record in the file filename.
+//
+// The file doesn't have to exist. Its directory must exist.
+func NewFileBackend(filename string) (backend Backend, err error) {
+	if filename == "" {
+		err = errors.New("filename can't be empty")
+		return
+	}
+
+	filename, err = filepath.Abs(filename)
+	if err != nil {
+		return
+	}
+
+	backend = &fileBackend{filename: filename}
+	return
+}
@@ -0,0 +1,54 @@
+//go:build unix
+
+package multi_cluster_lock
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Backends", func() {
+	ctx := context.Background()
+
+	Context("file backend", func() {
+		testBackendContract(func() Backend {
+			dir, err := os.MkdirTemp("", "multi-cluster-lock")
+			Ω(err).Should(BeNil())
+			DeferCleanup(os.RemoveAll, dir)
+
+			backend, err := NewFileBackend(filepath.Join(dir, "lock.json"))
+			Ω(err).Should(BeNil())
+			return backend
+		})
+
+		It("should share the record between backends on the same file", func() {
+			dir, err := os.MkdirTemp("", "multi-cluster-lock")
+			Ω(err).Should(BeNil())
+			DeferCleanup(os.RemoveAll, dir)
+
+			filename := filepath.Join(dir, "lock.json")
+			b1, err := NewFileBackend(filename)
+			Ω(err).Should(BeNil())
+			b2, err := NewFileBackend(filename)
+			Ω(err).Should(BeNil())
+
+			version, err := b1.Update(ctx, []byte("record-1"), "")
+			Ω(err).Should(BeNil())
+
+			data, curVersion, err := b2.Get(ctx)
+			Ω(err).Should(BeNil())
+			Ω(string(data)).Should(Equal("record-1"))
+			Ω(curVersion).Should(Equal(version))
+
+			_, err = b2.Update(ctx, []byte("record-2"), curVersion)
+			Ω(err).Should(BeNil())
+
+			_, err = b1.Update(ctx, []byte("record-3"), version)
+			Ω(err).Should(Equal(ErrConflict))
+		})
+	})
+})
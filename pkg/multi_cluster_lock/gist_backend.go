@@ -0,0 +1,74 @@
+package multi_cluster_lock
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+)
+
+// gistBackend keeps the lock record in a named file of a Github gist.
+//
+// Every lock uses its own file, so one gist can host many independent locks.
+// The version of the record is derived from the content of its file.
+// The Gist API has no atomic conditional writes. See GistClient.UpdateIfVersion for how concurrent writers are resolved.
+type gistBackend struct {
+	gistId   string
+	filename string
+	cli      *GistClient
+}
+
+func (b *gistBackend) Get(ctx context.Context) (record []byte, version string, err error) {
+	data, version, err := b.cli.GetWithVersion(ctx, b.gistId, b.filename)
+	if err != nil {
+		return
+	}
+
+	if version == "" {
+		err = ErrNotFound
+		return
+	}
+
+	record = []byte(data)
+	return
+}
+
+func (b *gistBackend) Update(ctx context.Context, record []byte, version string) (newVersion string, err error) {
+	return b.cli.UpdateIfVersion(ctx, b.gistId, b.filename, string(record), version)
+}
+
+func (b *gistBackend) Describe() string {
+	return "Github gist"
+}
+
+// NewGistBackend returns a backend that keeps the lock record in the file filename of the gist gistId.
+//
+// The file doesn't have to exist. It is created when the lock record is created.
+func NewGistBackend(gistId string, filename string, accessToken string) (backend Backend, err error) {
+	return NewGistBackendWithClient(gistId, filename, NewGistClient(accessToken))
+}
+
+// NewGistBackendWithClient returns a backend that keeps the lock record in the file filename
+// of the gist gistId and talks to the Gist API through cli
+func NewGistBackendWithClient(gistId string, filename string, cli *GistClient) (backend Backend, err error) {
+	if cli == nil {
+		err = errors.New("gist client can't be nil")
+		return
+	}
+
+	if filename == "" {
+		err = errors.New("filename can't be empty")
+		return
+	}
+
+	_, _, err = cli.GetWithVersion(context.Background(), gistId, filename)
+	if err != nil {
+		return
+	}
+
+	backend = &gistBackend{
+		gistId:   gistId,
+		filename: filename,
+		cli:      cli,
+	}
+	return
+}
@@ -2,40 +2,257 @@ package multi_cluster_lock
 
 import (
 	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"github.com/pkg/errors"
 	"io"
 	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 )
 
 const (
-	baseURL = "https://api.github.com/gists/"
+	defaultBaseURL    = "https://api.github.com"
+	defaultUserAgent  = "go-k8s-multi-cluster-lock"
+	defaultAPIVersion = "2022-11-28"
+
+	defaultTimeout      = 10 * time.Second
+	defaultMaxRetries   = 3
+	defaultRetryBackoff = 500 * time.Millisecond
+	maxRetryBackoff     = 10 * time.Second
+
+	// maxRestores is how many times a writer that lost a race puts back records it overwrote
+	maxRestores = 3
 )
 
 type GistClient struct {
-	cli         *http.Client
-	accessToken string
+	cli          *http.Client
+	tokens       TokenSource
+	baseURL      string
+	userAgent    string
+	apiVersion   string
+	maxRetries   int
+	retryBackoff time.Duration
+	metrics      *Metrics
+}
+
+// GistClientOptions customizes a GistClient. The zero value talks to api.github.com
+type GistClientOptions struct {
+	BaseURL    string            // Github API base URL (e.g. https://github.example.com/api/v3 for Github Enterprise Server)
+	HTTPClient *http.Client      // if nil, an http.Client with a 10 seconds timeout is used
+	Transport  http.RoundTripper // if not nil, replaces the transport of the http client
+	UserAgent  string            // value of the User-Agent header
+	APIVersion string            // value of the X-GitHub-Api-Version header
+	Metrics    *Metrics          // if not nil, records the latency of requests and the Github rate limit
+
+	// TokenSource provides the access token for every request (e.g. a rotated token file or Github App
+	// installation tokens). If not nil, the access token passed to NewGistClientWithOptions() must be empty
+	TokenSource TokenSource
+}
+
+// gistFiles returns the files of a gist object
+func gistFiles(gist map[string]any) (files map[string]any, err error) {
+	files, ok := gist["files"].(map[string]any)
+	if !ok {
+		message, _ := gist["message"].(string)
+		err = errors.Errorf("failed to get gist [%s]", message)
+	}
+	return
 }
 
-func (gc *GistClient) get(id string) (obj map[string]any, err error) {
-	url := baseURL + id
-	req, err := http.NewRequest("GET", url, nil)
+// gistContent returns the content of the file filename in a gist object.
+//
+// If the file doesn't exist the content is empty.
+func gistContent(gist map[string]any, filename string) (content string, err error) {
+	files, err := gistFiles(gist)
 	if err != nil {
 		return
 	}
 
-	req.Header.Add("Accept", "application/vnd.github+json")
-	req.Header.Add("Authorization", "Bearer "+gc.accessToken)
-	req.Header.Add("Accept", `application/json`)
+	file, _ := files[filename].(map[string]any)
+	content, _ = file["content"].(string)
+	return
+}
 
-	resp, err := gc.cli.Do(req)
+// firstFilename returns the name of the first file of a gist object in alphabetical order
+func firstFilename(gist map[string]any) (filename string, err error) {
+	files, err := gistFiles(gist)
 	if err != nil {
 		return
 	}
 
-	defer resp.Body.Close()
-	body, err := io.ReadAll(resp.Body)
+	if len(files) == 0 {
+		err = errors.New("gist has no files")
+		return
+	}
+
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	filename = names[0]
+	return
+}
+
+// contentVersion returns the version of file content. Empty content has an empty version
+func contentVersion(content string) (version string) {
+	if content == "" {
+		return
+	}
+
+	sum := sha256.Sum256([]byte(content))
+	version = hex.EncodeToString(sum[:])
+	return
+}
+
+// gistRevision returns the i-th most recent revision from the history of a gist object
+func gistRevision(gist map[string]any, i int) (version string) {
+	history, ok := gist["history"].([]any)
+	if !ok || len(history) <= i {
+		return
+	}
+
+	entry, ok := history[i].(map[string]any)
+	if !ok {
+		return
+	}
+
+	version, _ = entry["version"].(string)
+	return
+}
+
+// retryWait returns how long to wait before retrying a request and whether it should be retried at all
+func (gc *GistClient) retryWait(resp *http.Response, err error, attempt int) (wait time.Duration, retry bool) {
+	backoff := gc.retryBackoff << attempt
+	if backoff > maxRetryBackoff {
+		backoff = maxRetryBackoff
+	}
+
+	// Network errors are transient
+	if err != nil {
+		return backoff, true
+	}
+
+	// Github tells us how long to wait on secondary rate limits
+	if retryAfter := resp.Header.Get("Retry-After"); retryAfter != "" {
+		seconds, convErr := strconv.Atoi(retryAfter)
+		if convErr == nil {
+			return time.Duration(seconds) * time.Second, true
+		}
+	}
+
+	// Primary rate limit exhausted. Wait until it resets
+	if isRateLimited(resp) {
+		reset, convErr := strconv.ParseInt(resp.Header.Get("X-RateLimit-Reset"), 10, 64)
+		if convErr == nil {
+			return time.Until(time.Unix(reset, 0)), true
+		}
+		return backoff, true
+	}
+
+	return backoff, resp.StatusCode >= http.StatusInternalServerError
+}
+
+// do sends a request to the Gist API and returns the response along with its body.
+//
+// Error responses are returned as a *GistError.
+func (gc *GistClient) do(ctx context.Context, method string, url string, body []byte, header http.Header) (resp *http.Response, respBody []byte, err error) {
+	resp, respBody, err = gc.send(ctx, method, url, body, header)
+	if err == nil && resp.StatusCode >= http.StatusBadRequest {
+		err = newGistError(resp, respBody)
+	}
+	return
+}
+
+// send sends a request to the Gist API and returns the response along with its body.
+//
+// Network errors, 5xx responses and rate limited responses are retried with exponential
+// backoff (or as instructed by the Retry-After and X-RateLimit-Reset headers). If the
+// wait would exceed the deadline of ctx, send gives up immediately, so callers with a
+// deadline (e.g. the leader election renew loop) are never blocked past it.
+func (gc *GistClient) send(ctx context.Context, method string, url string, body []byte, header http.Header) (resp *http.Response, respBody []byte, err error) {
+	for attempt := 0; ; attempt++ {
+		// Get the token on every attempt, so a token that expires during the retries is replaced
+		var token string
+		token, err = gc.tokens.Token(ctx)
+		if err != nil {
+			err = errors.Wrap(err, "failed to get the access token")
+			return
+		}
+
+		var req *http.Request
+		req, err = http.NewRequestWithContext(ctx, method, url, bytes.NewReader(body))
+		if err != nil {
+			return
+		}
+
+		req.Header = header.Clone()
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("User-Agent", gc.userAgent)
+		req.Header.Set("X-GitHub-Api-Version", gc.apiVersion)
+
+		start := time.Now()
+		resp, err = gc.cli.Do(req)
+		gc.metrics.observeGistRequest(method, resp, start)
+		if err == nil {
+			respBody, err = io.ReadAll(resp.Body)
+			_ = resp.Body.Close()
+		}
+
+		wait, retry := gc.retryWait(resp, err, attempt)
+		if !retry || attempt >= gc.maxRetries {
+			return
+		}
+
+		// Don't retry if the caller gave up
+		if ctx.Err() != nil {
+			err = ctx.Err()
+			return
+		}
+
+		deadline, ok := ctx.Deadline()
+		if ok && time.Now().Add(wait).After(deadline) {
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			err = ctx.Err()
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+func (gc *GistClient) gistURL(id string) string {
+	return gc.baseURL + "/gists/" + url.PathEscape(id)
+}
+
+// get returns the gist object along with the ETag of the response
+func (gc *GistClient) get(ctx context.Context, id string) (obj map[string]any, etag string, err error) {
+	return gc.getRevision(ctx, id, "")
+}
+
+// getRevision returns the gist object at a specific revision along with the ETag of the response.
+//
+// If revision is empty it returns the latest revision.
+func (gc *GistClient) getRevision(ctx context.Context, id string, revision string) (obj map[string]any, etag string, err error) {
+	header := http.Header{}
+	header.Add("Accept", "application/vnd.github+json")
+	header.Add("Accept", `application/json`)
+
+	u := gc.gistURL(id)
+	if revision != "" {
+		u += "/" + url.PathEscape(revision)
+	}
+
+	resp, body, err := gc.do(ctx, http.MethodGet, u, nil, header)
 	if err != nil {
 		return
 	}
@@ -44,62 +261,86 @@ func (gc *GistClient) get(id string) (obj map[string]any, err error) {
 	if err != nil {
 		return
 	}
+
+	etag = resp.Header.Get("ETag")
 	return
 }
 
-func (gc *GistClient) update(id string, obj map[string]any) (err error) {
+// update sets the content of a gist file and returns the updated gist object.
+//
+// Only the file filename is sent, so other files in the gist are not touched.
+// If the file doesn't exist it is created.
+//
+// If etag is not empty the write is conditional and returns ErrConflict
+// if the server reports that the gist changed since the etag was captured.
+func (gc *GistClient) update(ctx context.Context, id string, filename string, content string, etag string) (updated map[string]any, err error) {
+	obj := map[string]any{
+		"files": map[string]any{
+			filename: map[string]any{"content": content},
+		},
+	}
 	data, err := json.Marshal(obj)
 	if err != nil {
 		return
 	}
 
-	url := baseURL + id
-	body := bytes.NewReader(data)
-	req, err := http.NewRequest("PATCH", url, body)
+	header := http.Header{}
+	header.Add("Accept", "application/vnd.github+json")
+	if etag != "" {
+		header.Add("If-Match", etag)
+	}
+
+	_, body, err := gc.do(ctx, http.MethodPatch, gc.gistURL(id), data, header)
 	if err != nil {
 		return
 	}
 
-	req.Header.Add("Accept", "application/vnd.github+json")
-	req.Header.Add("Authorization", "Bearer "+gc.accessToken)
-	_, err = gc.cli.Do(req)
+	err = json.Unmarshal(body, &updated)
 	return
 }
 
-func (gc *GistClient) Get(id string) (data string, err error) {
+// Get returns the content of the first file of the gist in alphabetical order
+func (gc *GistClient) Get(ctx context.Context, id string) (data string, err error) {
 	//	curl \
 	//	-H "Accept: application/vnd.github+json" \
 	//	-H "Authorization: Bearer <YOUR-TOKEN>" \
 	//https://api.github.com/gists/GIST_ID
 
-	obj, err := gc.get(id)
+	obj, _, err := gc.get(ctx, id)
 	if err != nil {
 		return
 	}
 
-	files, ok := obj["files"]
-	if !ok {
-		var message string
-		_, ok = obj["message"]
-		if ok {
-			message = obj["message"].(string)
-		}
-		err = errors.Errorf("failed to get gist [%s]", message)
+	filename, err := firstFilename(obj)
+	if err != nil {
 		return
 	}
 
-	fileMap := files.(map[string]any)
-	// get content of the first file
-	for _, file := range fileMap {
-		rawData := file.(map[string]any)["content"]
-		data = rawData.(string)
-		break
+	data, err = gistContent(obj, filename)
+	return
+}
+
+// GetWithVersion returns the content of the file filename in the gist along with its version.
+//
+// The version is derived from the content of the file, so changes to other files in the
+// gist don't change it. If the file doesn't exist or is empty, data and version are empty.
+func (gc *GistClient) GetWithVersion(ctx context.Context, id string, filename string) (data string, version string, err error) {
+	obj, _, err := gc.get(ctx, id)
+	if err != nil {
+		return
 	}
 
+	data, err = gistContent(obj, filename)
+	if err != nil {
+		return
+	}
+
+	version = contentVersion(data)
 	return
 }
 
-func (gc *GistClient) Update(id string, data string) (err error) {
+// Update sets the content of the first file of the gist in alphabetical order
+func (gc *GistClient) Update(ctx context.Context, id string, data string) (err error) {
 	//	curl \
 	//	-X PATCH \
 	//	-H "Accept: application/vnd.github+json" \
@@ -107,23 +348,203 @@ func (gc *GistClient) Update(id string, data string) (err error) {
 	//https://api.github.com/gists/GIST_ID \
 	//	-d '{"description":"An updated gist description","files":{"README.md":{"content":"Hello World from GitHub"}}}'
 
-	gist, err := gc.get(id)
+	gist, _, err := gc.get(ctx, id)
+	if err != nil {
+		return
+	}
+
+	filename, err := firstFilename(gist)
+	if err != nil {
+		return
+	}
+
+	_, err = gc.update(ctx, id, filename, data, "")
+	return
+}
+
+// UpdateIfVersion sets the content of the file filename in the gist only if its version is still version.
+//
+// An empty version means the file is expected to be missing or empty, in which case it is created.
+// It returns the new version.
+//
+// The write is sent with an If-Match header carrying the ETag of the gist as it was
+// read right before writing, so servers that support conditional writes reject it
+// atomically. api.github.com ignores If-Match, so concurrent writers may all write.
+// The revision history of the gist orders their writes: the first one that changed
+// our file after the revision we read wins. The others return ErrConflict and put
+// the record of the winner back if nobody changed the file since their own write
+// (see restore()). The winner reads the file again and returns ErrConflict if its record
+// isn't there (anymore), so it doesn't report a write that another writer overwrote.
+func (gc *GistClient) UpdateIfVersion(ctx context.Context, id string, filename string, data string, version string) (newVersion string, err error) {
+	gist, etag, err := gc.get(ctx, id)
+	if err != nil {
+		return
+	}
+
+	content, err := gistContent(gist, filename)
+	if err != nil {
+		return
+	}
+
+	if contentVersion(content) != version {
+		err = ErrConflict
+		return
+	}
+
+	readRevision := gistRevision(gist, 0)
+	updated, err := gc.update(ctx, id, filename, data, etag)
+	if err != nil {
+		return
+	}
+
+	newVersion = contentVersion(data)
+
+	// Our write was a no-op or the server keeps no history
+	if readRevision == "" || gistRevision(updated, 0) == readRevision {
+		return
+	}
+
+	winner, lost, err := gc.firstChange(ctx, id, filename, version, updated, readRevision)
+	if err != nil {
+		return
+	}
+	if lost {
+		err = gc.restore(ctx, id, filename, newVersion, winner)
+		if err == nil {
+			err = ErrConflict
+		}
+		return
+	}
+
+	// Nobody changed our file before us. Make sure nobody overwrote it after us either
+	latest, _, err := gc.get(ctx, id)
 	if err != nil {
 		return
 	}
 
-	fileMap := gist["files"].(map[string]any)
-	// get content of the first file
-	for _, file := range fileMap {
-		file.(map[string]any)["content"] = data
-		break
+	content, err = gistContent(latest, filename)
+	if err != nil {
+		return
 	}
 
-	err = gc.update(id, gist)
+	if contentVersion(content) != newVersion {
+		err = ErrConflict
+	}
 	return
 }
 
+// firstChange returns the content of the first revision between readRevision and our write (the latest
+// revision of updated) that changed the file filename from version. lost is false if there is none.
+//
+// If readRevision isn't in the history of updated, the order of the writes is unknown and ErrConflict is returned.
+func (gc *GistClient) firstChange(ctx context.Context, id string, filename string, version string, updated map[string]any, readRevision string) (content string, lost bool, err error) {
+	var between []string // newest first
+	for i := 1; ; i++ {
+		revision := gistRevision(updated, i)
+		if revision == "" {
+			err = ErrConflict
+			return
+		}
+		if revision == readRevision {
+			break
+		}
+		between = append(between, revision)
+	}
+
+	for i := len(between) - 1; i >= 0; i-- {
+		var gist map[string]any
+		gist, _, err = gc.getRevision(ctx, id, between[i])
+		if err != nil {
+			return
+		}
+
+		content, err = gistContent(gist, filename)
+		if err != nil {
+			return
+		}
+
+		if contentVersion(content) != version {
+			lost = true
+			return
+		}
+	}
+
+	content = ""
+	return
+}
+
+// restore puts content (the record of the writer that won) back into the file filename
+// if it is still at version, i.e. nobody changed it since we overwrote the record.
+//
+// api.github.com ignores If-Match, so a third writer may change the file between our check and our write.
+// The revision history tells: if someone did, we put their record back the same way, since they may have
+// verified their write already. After maxRestores attempts it gives up with ErrConflict.
+func (gc *GistClient) restore(ctx context.Context, id string, filename string, version string, content string) (err error) {
+	for i := 0; i < maxRestores; i++ {
+		var gist map[string]any
+		var etag string
+		gist, etag, err = gc.get(ctx, id)
+		if err != nil {
+			return
+		}
+
+		var current string
+		current, err = gistContent(gist, filename)
+		if err != nil {
+			return
+		}
+
+		if contentVersion(current) != version {
+			return
+		}
+
+		var restored map[string]any
+		restored, err = gc.update(ctx, id, filename, content, etag)
+		if err != nil {
+			return
+		}
+
+		// Nobody wrote between our check and our write, or the server keeps no history
+		previous := gistRevision(restored, 1)
+		if previous == "" || previous == gistRevision(gist, 0) {
+			return
+		}
+
+		gist, _, err = gc.getRevision(ctx, id, previous)
+		if err != nil {
+			return
+		}
+
+		current, err = gistContent(gist, filename)
+		if err != nil {
+			return
+		}
+
+		// Someone wrote another file
+		if contentVersion(current) == version {
+			return
+		}
+
+		// We overwrote the record of a third writer, put it back
+		version, content = contentVersion(content), current
+	}
+
+	err = errors.Wrapf(ErrConflict, "gave up restoring %s after %d attempts", filename, maxRestores)
+	return
+}
+
+// NewGistClient returns a GistClient that authenticates with a static access token
 func NewGistClient(accessToken string) (gc *GistClient) {
+	// The default options are always valid
+	gc, _ = NewGistClientWithOptions(accessToken, GistClientOptions{})
+	return
+}
+
+// NewGistClientWithOptions returns a GistClient customized by options.
+//
+// Use it to talk to Github Enterprise Server or to a FakeGistServer in tests.
+// The client authenticates with accessToken, or with the tokens of options.TokenSource if accessToken is empty.
+func NewGistClientWithOptions(accessToken string, options GistClientOptions) (gc *GistClient, err error) {
 	//ctx := context.Background()
 	//sts := oauth2.StaticTokenSource(
 	//	&oauth2.Token{AccessToken: accessToken},
@@ -133,11 +554,57 @@ func NewGistClient(accessToken string) (gc *GistClient) {
 	//	client: github.NewClient(tc),
 	//}
 
-	// Clean up access token from inadvertent newlines
-	accessToken = strings.Replace(accessToken, "\n", "", -1)
+	baseURL := options.BaseURL
+	if baseURL == "" {
+		baseURL = defaultBaseURL
+	}
+
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		err = errors.Errorf("invalid base URL: %s", baseURL)
+		return
+	}
+
+	cli := options.HTTPClient
+	if cli == nil {
+		cli = &http.Client{Timeout: defaultTimeout}
+	}
+	if options.Transport != nil {
+		c := *cli
+		c.Transport = options.Transport
+		cli = &c
+	}
+
+	userAgent := options.UserAgent
+	if userAgent == "" {
+		userAgent = defaultUserAgent
+	}
+
+	apiVersion := options.APIVersion
+	if apiVersion == "" {
+		apiVersion = defaultAPIVersion
+	}
+
+	tokens := options.TokenSource
+	if tokens == nil {
+		tokens = StaticTokenSource(accessToken)
+	} else if accessToken != "" {
+		err = errors.New("pass either an access token or a token source, not both")
+		return
+	}
+
 	gc = &GistClient{
-		accessToken: accessToken,
-		cli:         &http.Client{},
+		tokens:       tokens,
+		cli:          cli,
+		baseURL:      strings.TrimSuffix(baseURL, "/"),
+		userAgent:    userAgent,
+		apiVersion:   apiVersion,
+		maxRetries:   defaultMaxRetries,
+		retryBackoff: defaultRetryBackoff,
+		metrics:      options.Metrics,
 	}
 	return
 }
@@ -1,7 +1,13 @@
 package multi_cluster_lock
 
 import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"strconv"
+	"time"
 
 	"github.com/joho/godotenv"
 	. "github.com/onsi/ginkgo/v2"
@@ -13,6 +19,7 @@ const (
 )
 
 var _ = Describe("GistClient", func() {
+	ctx := context.Background()
 	var cli *GistClient
 
 	BeforeEach(func() {
@@ -30,28 +37,394 @@ var _ = Describe("GistClient", func() {
 	})
 
 	It("should get private gist", func() {
-		data, err := cli.Get(privateGistId)
+		data, err := cli.Get(ctx, privateGistId)
 		Ω(err).Should(BeNil())
 		Ω(data).Should(Equal("secret"))
 	})
 
 	It("should update private gist", func() {
-		data, err := cli.Get(privateGistId)
+		data, err := cli.Get(ctx, privateGistId)
 		Ω(err).Should(BeNil())
 		Ω(data).Should(Equal("secret"))
 
-		err = cli.Update(privateGistId, "secret2")
+		err = cli.Update(ctx, privateGistId, "secret2")
 		Ω(err).Should(BeNil())
 
-		data, err = cli.Get(privateGistId)
+		data, err = cli.Get(ctx, privateGistId)
 		Ω(err).Should(BeNil())
 		Ω(data).Should(Equal("secret2"))
 
-		err = cli.Update(privateGistId, "secret")
+		err = cli.Update(ctx, privateGistId, "secret")
 		Ω(err).Should(BeNil())
 
-		data, err = cli.Get(privateGistId)
+		data, err = cli.Get(ctx, privateGistId)
 		Ω(err).Should(BeNil())
 		Ω(data).Should(Equal("secret"))
 	})
 })
+
+var _ = Describe("GistClient conditional writes", func() {
+	ctx := context.Background()
+	var server *FakeGistServer
+	var cli *GistClient
+	var version1 = contentVersion("record-1")
+
+	BeforeEach(func() {
+		server = NewFakeGistServer()
+		DeferCleanup(server.Close)
+		server.Put("gist-1", "lock.json", "record-1")
+		server.Put("gist-1", "other-lock.json", "other-record-1")
+		cli = newTestClient(server)
+	})
+
+	// raceWrites makes the server write content to filename right before every PATCH of the client
+	raceWrites := func(filename string, content string) {
+		transport := cli.cli.Transport
+		cli.cli.Transport = roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			if req.Method == http.MethodPatch {
+				server.Put("gist-1", filename, content)
+			}
+			return transport.RoundTrip(req)
+		})
+	}
+
+	// lateWrites makes someone write content to filename right after our next write
+	lateWrites := func(filename string, content string) {
+		transport := cli.cli.Transport
+		cli.cli.Transport = roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			resp, err := transport.RoundTrip(req)
+			if req.Method == http.MethodPatch {
+				server.Put("gist-1", filename, content)
+			}
+			return resp, err
+		})
+	}
+
+	It("should get the content of a named file along with the version", func() {
+		data, version, err := cli.GetWithVersion(ctx, "gist-1", "lock.json")
+		Ω(err).Should(BeNil())
+		Ω(data).Should(Equal("record-1"))
+		Ω(version).Should(Equal(version1))
+
+		data, version, err = cli.GetWithVersion(ctx, "gist-1", "other-lock.json")
+		Ω(err).Should(BeNil())
+		Ω(data).Should(Equal("other-record-1"))
+		Ω(version).ShouldNot(Equal(version1))
+	})
+
+	It("should get an empty version for a missing file", func() {
+		data, version, err := cli.GetWithVersion(ctx, "gist-1", "no-such-file.json")
+		Ω(err).Should(BeNil())
+		Ω(data).Should(BeEmpty())
+		Ω(version).Should(BeEmpty())
+	})
+
+	It("should always read the first file in alphabetical order", func() {
+		for i := 0; i < 10; i++ {
+			data, err := cli.Get(ctx, "gist-1")
+			Ω(err).Should(BeNil())
+			Ω(data).Should(Equal("record-1"))
+		}
+	})
+
+	It("should update if the version matches", func() {
+		newVersion, err := cli.UpdateIfVersion(ctx, "gist-1", "lock.json", "record-2", version1)
+		Ω(err).Should(BeNil())
+
+		data, version, err := cli.GetWithVersion(ctx, "gist-1", "lock.json")
+		Ω(err).Should(BeNil())
+		Ω(data).Should(Equal("record-2"))
+		Ω(version).Should(Equal(newVersion))
+		Ω(server.Content("gist-1", "other-lock.json")).Should(Equal("other-record-1"))
+	})
+
+	It("should create a missing file", func() {
+		_, err := cli.UpdateIfVersion(ctx, "gist-1", "new-lock.json", "record-1", "")
+		Ω(err).Should(BeNil())
+		Ω(server.Content("gist-1", "new-lock.json")).Should(Equal("record-1"))
+
+		_, err = cli.UpdateIfVersion(ctx, "gist-1", "new-lock.json", "record-2", "")
+		Ω(err).Should(Equal(ErrConflict))
+	})
+
+	It("should not be affected by changes to other files", func() {
+		server.Put("gist-1", "other-lock.json", "other-record-2")
+
+		_, err := cli.UpdateIfVersion(ctx, "gist-1", "lock.json", "record-2", version1)
+		Ω(err).Should(BeNil())
+	})
+
+	It("should fail fast if the version is stale", func() {
+		server.Put("gist-1", "lock.json", "record-2")
+
+		_, err := cli.UpdateIfVersion(ctx, "gist-1", "lock.json", "record-3", version1)
+		Ω(err).Should(Equal(ErrConflict))
+		Ω(server.Content("gist-1", "lock.json")).Should(Equal("record-2"))
+	})
+
+	It("should detect a write to the same file from the revision history", func() {
+		raceWrites("lock.json", "racer")
+
+		_, err := cli.UpdateIfVersion(ctx, "gist-1", "lock.json", "record-2", version1)
+		Ω(err).Should(MatchError(ErrConflict))
+
+		// The record of the writer that won is put back
+		Ω(server.Content("gist-1", "lock.json")).Should(Equal("racer"))
+	})
+
+	It("should detect that its write was overwritten", func() {
+		lateWrites("lock.json", "late")
+
+		_, err := cli.UpdateIfVersion(ctx, "gist-1", "lock.json", "record-2", version1)
+		Ω(err).Should(MatchError(ErrConflict))
+		Ω(server.Content("gist-1", "lock.json")).Should(Equal("late"))
+	})
+
+	It("should ignore a write to another file", func() {
+		raceWrites("other-lock.json", "racer")
+
+		_, err := cli.UpdateIfVersion(ctx, "gist-1", "lock.json", "record-2", version1)
+		Ω(err).Should(BeNil())
+		Ω(server.Content("gist-1", "lock.json")).Should(Equal("record-2"))
+	})
+
+	It("should put back the record of a third writer it overwrote while restoring", func() {
+		// The winner writes right before our write, and a third writer right before we restore the record of the winner
+		transport := cli.cli.Transport
+		patches := 0
+		cli.cli.Transport = roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			if req.Method == http.MethodPatch {
+				patches++
+				switch patches {
+				case 1:
+					server.Put("gist-1", "lock.json", "winner")
+				case 2:
+					server.Put("gist-1", "lock.json", "third")
+				}
+			}
+			return transport.RoundTrip(req)
+		})
+
+		_, err := cli.UpdateIfVersion(ctx, "gist-1", "lock.json", "record-2", version1)
+		Ω(err).Should(MatchError(ErrConflict))
+		Ω(server.Content("gist-1", "lock.json")).Should(Equal("third"))
+		Ω(patches).Should(Equal(3))
+	})
+
+	It("should not restore over a write that landed after its own", func() {
+		// The winner writes right before our write, and a third writer right after it
+		transport := cli.cli.Transport
+		patches := 0
+		cli.cli.Transport = roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			if req.Method != http.MethodPatch {
+				return transport.RoundTrip(req)
+			}
+
+			patches++
+			if patches == 1 {
+				server.Put("gist-1", "lock.json", "winner")
+			}
+			resp, err := transport.RoundTrip(req)
+			if patches == 1 {
+				server.Put("gist-1", "lock.json", "third")
+			}
+			return resp, err
+		})
+
+		_, err := cli.UpdateIfVersion(ctx, "gist-1", "lock.json", "record-2", version1)
+		Ω(err).Should(MatchError(ErrConflict))
+		Ω(server.Content("gist-1", "lock.json")).Should(Equal("third"))
+		Ω(patches).Should(Equal(1))
+	})
+
+	Context("when the server honors If-Match", func() {
+		BeforeEach(func() {
+			server.HonorIfMatch = true
+		})
+
+		It("should fail if someone writes between the read and the write", func() {
+			raceWrites("lock.json", "racer")
+
+			_, err := cli.UpdateIfVersion(ctx, "gist-1", "lock.json", "record-2", version1)
+			Ω(err).Should(MatchError(ErrConflict))
+			Ω(server.Content("gist-1", "lock.json")).Should(Equal("racer"))
+		})
+	})
+})
+
+var _ = Describe("GistClient retries", func() {
+	var server *FakeGistServer
+	var cli *GistClient
+
+	BeforeEach(func() {
+		server = NewFakeGistServer()
+		DeferCleanup(server.Close)
+		server.Put("gist-1", "lock.json", "record-1")
+		cli = newTestClient(server)
+	})
+
+	It("should retry transient server errors", func() {
+		server.Fail(http.StatusBadGateway, nil)
+		server.Fail(http.StatusServiceUnavailable, nil)
+
+		data, err := cli.Get(context.Background(), "gist-1")
+		Ω(err).Should(BeNil())
+		Ω(data).Should(Equal("record-1"))
+	})
+
+	It("should honour Retry-After", func() {
+		server.Fail(http.StatusForbidden, http.Header{"Retry-After": []string{"1"}})
+
+		start := time.Now()
+		data, err := cli.Get(context.Background(), "gist-1")
+		Ω(err).Should(BeNil())
+		Ω(data).Should(Equal("record-1"))
+		Ω(time.Since(start)).Should(BeNumerically(">=", time.Second))
+	})
+
+	It("should not wait for a rate limit reset past the deadline", func() {
+		reset := strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10)
+		server.Fail(http.StatusForbidden, http.Header{
+			"X-Ratelimit-Remaining": []string{"0"},
+			"X-Ratelimit-Reset":     []string{reset},
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+
+		start := time.Now()
+		_, err := cli.Get(ctx, "gist-1")
+		Ω(err).ShouldNot(BeNil())
+		Ω(time.Since(start)).Should(BeNumerically("<", time.Second))
+	})
+
+	It("should stop when the context is cancelled", func() {
+		for i := 0; i < 10; i++ {
+			server.Fail(http.StatusInternalServerError, nil)
+		}
+		cli.retryBackoff = time.Hour
+
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(100*time.Millisecond, cancel)
+
+		_, err := cli.Get(ctx, "gist-1")
+		Ω(err).Should(Equal(context.Canceled))
+	})
+})
+
+var _ = Describe("GistClient errors", func() {
+	ctx := context.Background()
+	var server *FakeGistServer
+	var cli *GistClient
+
+	BeforeEach(func() {
+		server = NewFakeGistServer()
+		DeferCleanup(server.Close)
+		server.Put("gist-1", "lock.json", "record-1")
+		cli = newTestClient(server)
+	})
+
+	It("should return not found for a missing gist", func() {
+		_, err := cli.Get(ctx, "no-such-gist")
+		Ω(err).Should(MatchError(ErrGistNotFound))
+
+		var gistErr *GistError
+		Ω(errors.As(err, &gistErr)).Should(BeTrue())
+		Ω(gistErr.StatusCode).Should(Equal(http.StatusNotFound))
+		Ω(gistErr.Message).Should(Equal("Not Found"))
+	})
+
+	It("should decode Github error responses", func() {
+		server.FailWith(http.StatusUnauthorized, nil, `{"message": "Bad credentials", "documentation_url": "https://docs.github.com/rest"}`)
+
+		_, err := cli.Get(ctx, "gist-1")
+		Ω(err).Should(MatchError(ErrUnauthorized))
+		Ω(err.Error()).Should(ContainSubstring("Bad credentials"))
+		Ω(err.Error()).Should(ContainSubstring("https://docs.github.com/rest"))
+	})
+
+	It("should surface validation errors from updates", func() {
+		transport := cli.cli.Transport
+		cli.cli.Transport = roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			if req.Method == http.MethodPatch {
+				server.Fail(http.StatusUnprocessableEntity, nil)
+			}
+			return transport.RoundTrip(req)
+		})
+
+		err := cli.Update(ctx, "gist-1", "record-2")
+		Ω(err).Should(MatchError(ErrValidationFailed))
+
+		_, err = cli.UpdateIfVersion(ctx, "gist-1", "lock.json", "record-2", contentVersion("record-1"))
+		Ω(err).Should(MatchError(ErrValidationFailed))
+	})
+
+	It("should report rate limits", func() {
+		cli.maxRetries = 0
+		server.Fail(http.StatusForbidden, http.Header{"X-Ratelimit-Remaining": []string{"0"}})
+
+		_, err := cli.Get(ctx, "gist-1")
+		Ω(err).Should(MatchError(ErrRateLimited))
+	})
+})
+
+var _ = Describe("GistClient options", func() {
+	It("should reject an invalid base URL", func() {
+		_, err := NewGistClientWithOptions("token", GistClientOptions{BaseURL: "not a url"})
+		Ω(err).ShouldNot(BeNil())
+	})
+
+	It("should talk to a custom base URL with custom headers", func() {
+		var userAgent, apiVersion, path string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			userAgent = r.Header.Get("User-Agent")
+			apiVersion = r.Header.Get("X-GitHub-Api-Version")
+			path = r.URL.Path
+			_, _ = w.Write([]byte(`{"files": {"lock.json": {"content": "record-1"}}}`))
+		}))
+		DeferCleanup(server.Close)
+
+		cli, err := NewGistClientWithOptions("token", GistClientOptions{
+			BaseURL:    server.URL + "/api/v3/",
+			HTTPClient: server.Client(),
+			UserAgent:  "my-agent",
+			APIVersion: "2099-01-01",
+		})
+		Ω(err).Should(BeNil())
+
+		data, err := cli.Get(context.Background(), "gist-1")
+		Ω(err).Should(BeNil())
+		Ω(data).Should(Equal("record-1"))
+		Ω(path).Should(Equal("/api/v3/gists/gist-1"))
+		Ω(userAgent).Should(Equal("my-agent"))
+		Ω(apiVersion).Should(Equal("2099-01-01"))
+	})
+
+	It("should work with the fake gist server", func() {
+		server := NewFakeGistServer()
+		DeferCleanup(server.Close)
+		server.Put("gist-1", "lock.json", "record-1")
+
+		cli := server.NewClient("token")
+		err := cli.Update(context.Background(), "gist-1", "record-2")
+		Ω(err).Should(BeNil())
+		Ω(server.Content("gist-1", "lock.json")).Should(Equal("record-2"))
+	})
+})
+
+// newTestClient returns a client of the fake server that retries without delay
+func newTestClient(server *FakeGistServer) *GistClient {
+	gc, err := NewGistClientWithOptions("token", GistClientOptions{
+		BaseURL:   server.URL,
+		Transport: http.DefaultTransport,
+	})
+	Ω(err).Should(BeNil())
+	gc.retryBackoff = time.Millisecond
+	return gc
+}
+
+type roundTripFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
@@ -0,0 +1,100 @@
+package multi_cluster_lock
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrUnauthorized is returned when the Gist API rejects the access token
+	ErrUnauthorized = errors.New("unauthorized")
+
+	// ErrForbidden is returned when the access token is not allowed to access the gist
+	ErrForbidden = errors.New("forbidden")
+
+	// ErrGistNotFound is returned when the gist doesn't exist (or the token can't see it)
+	ErrGistNotFound = errors.New("gist not found")
+
+	// ErrRateLimited is returned when the Github API rate limit is exhausted
+	ErrRateLimited = errors.New("rate limited")
+
+	// ErrValidationFailed is returned when the Gist API rejects the request payload
+	ErrValidationFailed = errors.New("validation failed")
+)
+
+// GistError is an error response from the Gist API.
+//
+// Use errors.Is() with ErrUnauthorized, ErrForbidden, ErrGistNotFound, ErrRateLimited,
+// ErrValidationFailed or ErrConflict to check what kind of error it is.
+type GistError struct {
+	StatusCode       int
+	Message          string
+	DocumentationURL string
+	RetryAfter       time.Duration // how long to wait before retrying if rate limited
+	Err              error         // the kind of error, nil if the status is not recognized
+}
+
+func (e *GistError) Error() string {
+	msg := fmt.Sprintf("gist API error %d", e.StatusCode)
+	if e.Message != "" {
+		msg += ": " + e.Message
+	}
+	if e.DocumentationURL != "" {
+		msg += " (" + e.DocumentationURL + ")"
+	}
+	return msg
+}
+
+func (e *GistError) Unwrap() error {
+	return e.Err
+}
+
+// isRateLimited checks if a response was rejected because of a primary or secondary rate limit
+func isRateLimited(resp *http.Response) bool {
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return true
+	}
+
+	return resp.StatusCode == http.StatusForbidden &&
+		(resp.Header.Get("X-RateLimit-Remaining") == "0" || resp.Header.Get("Retry-After") != "")
+}
+
+// newGistError decodes an error response of the Gist API
+func newGistError(resp *http.Response, body []byte) (err *GistError) {
+	err = &GistError{StatusCode: resp.StatusCode}
+
+	var payload struct {
+		Message          string `json:"message"`
+		DocumentationURL string `json:"documentation_url"`
+	}
+	if json.Unmarshal(body, &payload) == nil {
+		err.Message = payload.Message
+		err.DocumentationURL = payload.DocumentationURL
+	}
+
+	switch {
+	case isRateLimited(resp):
+		err.Err = ErrRateLimited
+		if seconds, convErr := strconv.Atoi(resp.Header.Get("Retry-After")); convErr == nil {
+			err.RetryAfter = time.Duration(seconds) * time.Second
+		} else if reset, convErr := strconv.ParseInt(resp.Header.Get("X-RateLimit-Reset"), 10, 64); convErr == nil {
+			err.RetryAfter = time.Until(time.Unix(reset, 0))
+		}
+	case resp.StatusCode == http.StatusUnauthorized:
+		err.Err = ErrUnauthorized
+	case resp.StatusCode == http.StatusForbidden:
+		err.Err = ErrForbidden
+	case resp.StatusCode == http.StatusNotFound:
+		err.Err = ErrGistNotFound
+	case resp.StatusCode == http.StatusConflict || resp.StatusCode == http.StatusPreconditionFailed:
+		err.Err = ErrConflict
+	case resp.StatusCode == http.StatusUnprocessableEntity:
+		err.Err = ErrValidationFailed
+	}
+	return
+}
@@ -3,142 +3,505 @@ package multi_cluster_lock
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	pkgerrors "github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/client-go/tools/leaderelection/resourcelock"
-	"math/rand/v2"
+	"k8s.io/utils/clock"
+	"net/http"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
+var qualifiedResource = schema.GroupResource{
+	Group:    "coordination.k8s.io",
+	Resource: "Lease",
+}
+
+// gistLock implements the multi-cluster lock algorithm on top of a Backend.
+//
+// It was originally written for Github gists, hence the name, but the storage
+// is pluggable and the same algorithm works with any Backend.
 type gistLock struct {
 	identity string
-	gistId   string
-	cli      *GistClient
+	backend  Backend
+	options  LockOptions
+	sealer   *sealer // nil if records are stored in plain JSON
+	clock    clock.PassiveClock
+	token    atomic.Int64 // the fencing token of our current leadership
+
+	// The version of the record we saw last and when we first saw it (by our clock)
+	m               sync.Mutex
+	observedVersion string
+	observedTime    time.Time
+}
+
+// LockOptions configures the optional features of a multi-cluster lock
+type LockOptions struct {
+	// EventRecorder records the leadership transitions as Kubernetes Events, e.g. from NewEventRecorder()
+	EventRecorder resourcelock.EventRecorder
+	// EventNamespace and EventName identify the Lease the events refer to.
+	// They default to "default" and "multi-cluster-lock"
+	EventNamespace string
+	EventName      string
+
+	// HistorySize is the number of transitions kept in the lock record (see TransitionHistory()).
+	// 0 means no history.
+	HistorySize int
+
+	// Metrics records the operations of the lock, e.g. from NewMetrics()
+	Metrics *Metrics
+
+	// Secret signs the record with HMAC-SHA256, so records written without it are rejected with ErrTamperedRecord.
+	// It must be at least 16 bytes long and shared by all the instances. Surrounding whitespace (e.g. the trailing
+	// newline of a file) is ignored. If empty, the record is stored in plain JSON.
+	Secret []byte
+	// Encrypt encrypts the record with AES-256-GCM before signing it, so the identities of the clusters
+	// don't leak to everyone with access to the backend. It requires a Secret
+	Encrypt bool
+
+	// SkewAllowance is how far the clocks of the instances may be apart. A lease is considered valid
+	// for LeaseDurationSeconds from when the lock first saw the record by its own clock (see leaseExpiresAt()),
+	// and for SkewAllowance beyond its RenewTime + LeaseDurationSeconds, but at most SkewAllowance longer than that
+	SkewAllowance time.Duration
+	// Clock is the clock of the lock. Defaults to the real clock. Inject a fake clock in tests
+	Clock clock.PassiveClock
+
+	// Metadata is stored in the record while we hold the lock (see LockStatus.Metadata)
+	Metadata *HolderMetadata
+	// Eligible is asked before every attempt to acquire the lock. If it returns false (e.g. because the nodes
+	// of our cluster are NotReady) the attempt fails with an error that IsNotEligible() reports. A holder keeps renewing
+	Eligible EligibilityFunc
+	// PreferredRegions are the regions that should hold the lock, most preferred first. When a lease expires
+	// or is released, an instance waits TakeoverDelay for every region ranked before the Region of its Metadata
+	// (instances outside the preferred regions rank last) before it takes over, so the most preferred region wins.
+	// A holder in a less preferred region stops renewing when an eligible instance in a more preferred region
+	// asks for the lock (see AskForLock), so leadership returns to the preferred region.
+	// TakeoverDelay should exceed the retry period of the leader election
+	PreferredRegions []string
+	TakeoverDelay    time.Duration
+	// AskForLock makes an eligible instance in a more preferred region than the holder ask for the lock when it
+	// reads the record, so Get() writes to the backend (see contend()). The leader election calls Update() only
+	// once the lease expired, so without it the leadership returns to the preferred region only when the holder
+	// loses or releases the lock. It requires PreferredRegions
+	AskForLock bool
+}
+
+// reasonTampered is the reason of the API error for a tampered record
+const reasonTampered metav1.StatusReason = "Tampered"
+
+// IsTampered returns true if err reports a record that isn't signed with the secret of the lock
+func IsTampered(err error) bool {
+	return pkgerrors.Is(err, ErrTamperedRecord) || errors.ReasonForError(err) == reasonTampered
+}
+
+// isMalformed returns true if err reports a record that isn't valid JSON
+func isMalformed(err error) bool {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	return pkgerrors.As(err, &syntaxErr) || pkgerrors.As(err, &typeErr)
+}
+
+// toAPIError converts backend errors to the Kubernetes API errors the leader election can work with
+//
+// A missing or malformed record is reported as NotFound, so the leader election will try to create it.
+func (gl *gistLock) toAPIError(err error) error {
+	name := gl.backend.Describe()
+
+	var gistErr *GistError
+	var apiErr errors.APIStatus
+	switch {
+	case err == nil:
+		return nil
+	case pkgerrors.As(err, &apiErr):
+		// Already a Kubernetes API error (e.g. from a Lease backend)
+		return err
+	case pkgerrors.Is(err, ErrTamperedRecord):
+		// Don't let the leader election replace the record. Someone has to look into it
+		return &errors.StatusError{ErrStatus: metav1.Status{
+			Status:  metav1.StatusFailure,
+			Code:    http.StatusUnprocessableEntity,
+			Reason:  reasonTampered,
+			Message: fmt.Sprintf("%s %q: %v", qualifiedResource.String(), name, err),
+			Details: &metav1.StatusDetails{
+				Group: qualifiedResource.Group,
+				Kind:  qualifiedResource.Resource,
+				Name:  name,
+			},
+		}}
+	case pkgerrors.Is(err, ErrNotFound), pkgerrors.Is(err, ErrGistNotFound):
+		return errors.NewNotFound(qualifiedResource, name)
+	case isMalformed(err):
+		return errors.NewNotFound(qualifiedResource, name)
+	case pkgerrors.Is(err, ErrConflict):
+		return errors.NewConflict(qualifiedResource, name, err)
+	case pkgerrors.Is(err, ErrUnauthorized):
+		return errors.NewUnauthorized(err.Error())
+	case pkgerrors.Is(err, ErrForbidden):
+		return errors.NewForbidden(qualifiedResource, name, err)
+	case pkgerrors.Is(err, ErrRateLimited) && pkgerrors.As(err, &gistErr):
+		return errors.NewTooManyRequests(err.Error(), int(gistErr.RetryAfter.Seconds()))
+	case pkgerrors.Is(err, ErrValidationFailed):
+		return errors.NewBadRequest(err.Error())
+	default:
+		return errors.NewInternalError(err)
+	}
+}
+
+// observe records that the record is at version
+func (gl *gistLock) observe(version string) {
+	gl.m.Lock()
+	defer gl.m.Unlock()
+
+	if version == gl.observedVersion {
+		return
+	}
+
+	gl.observedVersion = version
+	gl.observedTime = gl.clock.Now()
+}
+
+// observeWrite records that we wrote version of the record just now
+func (gl *gistLock) observeWrite(version string) {
+	gl.m.Lock()
+	defer gl.m.Unlock()
+
+	gl.observedVersion = version
+	gl.observedTime = gl.clock.Now()
+}
+
+// leaseValid returns true if the lease of record, which is at version, hasn't expired.
+// A record without a holder (e.g. a released lock) has no valid lease.
+func (gl *gistLock) leaseValid(record *lockRecord, version string) bool {
+	return gl.leaseValidAt(record, version, gl.clock.Now())
+}
+
+// leaseValidAt returns true if the lease of record, which is at version, is valid at now by our clock
+func (gl *gistLock) leaseValidAt(record *lockRecord, version string, now time.Time) bool {
+	return record.HolderIdentity != "" && gl.leaseExpiresAt(&record.LeaderElectionRecord, version).After(now)
+}
+
+// leaseExpiresAt returns when the lease of record, which is at version, expires by our clock.
+//
+// The RenewTime of the record comes from the clock of its holder, which may be skewed against ours.
+// So, like the observedTime of the client-go leader election, we also measure the lease from when we
+// first saw this version of the record by our own clock. The lease expires when both say so: a lock
+// whose clock runs ahead doesn't take over a live lease, even on its first read. See leaseExpiry().
+func (gl *gistLock) leaseExpiresAt(record *resourcelock.LeaderElectionRecord, version string) (expiry time.Time) {
+	leaseDuration := time.Duration(record.LeaseDurationSeconds) * time.Second
+	return gl.leaseExpiry(record.RenewTime.Time, leaseDuration, gl.observedTimeOf(version))
+}
+
+// observedTimeOf returns when we first saw version of the record (by our clock) or zero if it isn't the version we saw last
+func (gl *gistLock) observedTimeOf(version string) (observedTime time.Time) {
+	gl.m.Lock()
+	defer gl.m.Unlock()
+
+	if gl.observedVersion == version {
+		observedTime = gl.observedTime
+	}
+	return
+}
+
+// leaseExpiry returns when a lease of leaseDuration that its holder renewed at renewTime (by its clock)
+// and we first saw at observedTime (by our clock, zero if we didn't see it) expires by our clock.
+//
+// The RenewTime extends the lease by at most the skew allowance beyond the observed time, so a holder
+// whose clock runs ahead (or that wrote a RenewTime in the future) doesn't block the failover when it dies.
+func (gl *gistLock) leaseExpiry(renewTime time.Time, leaseDuration time.Duration, observedTime time.Time) (expiry time.Time) {
+	expiry = renewTime.Add(leaseDuration + gl.options.SkewAllowance)
+	if observedTime.IsZero() {
+		return
+	}
+
+	observed := observedTime.Add(leaseDuration)
+	if limit := observed.Add(gl.options.SkewAllowance); expiry.After(limit) {
+		expiry = limit
+	}
+	if observed.After(expiry) {
+		expiry = observed
+	}
+	return
 }
 
-func gistToLeaderElectionRecord(gist []byte) (record *resourcelock.LeaderElectionRecord, err error) {
-	var rel resourcelock.LeaderElectionRecord
-	err = json.Unmarshal(gist, &rel)
+// leaseStillValid returns the error of an attempt to take over a valid lease
+func (gl *gistLock) leaseStillValid() error {
+	return errors.NewConflict(qualifiedResource, gl.backend.Describe(), pkgerrors.New("lease is still valid"))
+}
+
+func gistToLockRecord(gist []byte) (record *lockRecord, err error) {
+	var lr lockRecord
+	err = json.Unmarshal(gist, &lr)
 	if err != nil {
 		return
 	}
 
-	record = &rel
+	record = &lr
 	return
 }
 
-// Get returns the LeaderElectionRecord
-func (gl *gistLock) Get(ctx context.Context) (record *resourcelock.LeaderElectionRecord, recordBytes []byte, err error) {
-	defer func() {
-		// Convert any error to NotFound, which is what the leader election can work with
-		if err != nil {
-			qualifiedResource := schema.GroupResource{
-				Group:    "coordination.k8s.io",
-				Resource: "Lease",
-			}
-			err = errors.NewNotFound(qualifiedResource, gl.gistId)
-			return
-		}
-	}()
-	gist, err := gl.cli.Get(gl.gistId)
+// backendGet reads the record from the backend and records the latency
+func (gl *gistLock) backendGet(ctx context.Context) (data []byte, version string, err error) {
+	defer gl.options.Metrics.observeBackend(operationGet, time.Now())
+	return gl.backend.Get(ctx)
+}
+
+// backendUpdate writes the record to the backend and records the latency.
+// If the backend is an Expirer, the record expires ttl after the write (if ttl is positive).
+func (gl *gistLock) backendUpdate(ctx context.Context, data []byte, version string, ttl time.Duration) (newVersion string, err error) {
+	defer gl.options.Metrics.observeBackend(operationUpdate, time.Now())
+	if expirer, ok := gl.backend.(Expirer); ok && ttl > 0 {
+		return expirer.UpdateWithTTL(ctx, data, version, ttl)
+	}
+	return gl.backend.Update(ctx, data, version)
+}
+
+// decode returns the record stored in the backend as data, verifying and decrypting it if the lock has a secret
+func (gl *gistLock) decode(data []byte) (record *lockRecord, err error) {
+	data, err = gl.open(data)
 	if err != nil {
 		return
 	}
 
-	record, err = gistToLeaderElectionRecord([]byte(gist))
+	return gistToLockRecord(data)
+}
+
+// open verifies and decrypts data if the lock has a secret and returns the plain JSON
+func (gl *gistLock) open(data []byte) (plain []byte, err error) {
+	if gl.sealer == nil {
+		return data, nil
+	}
+
+	return gl.sealer.open(data)
+}
+
+// seal signs and encrypts plain JSON if the lock has a secret
+func (gl *gistLock) seal(plain []byte) (data []byte, err error) {
+	if gl.sealer == nil {
+		return plain, nil
+	}
+
+	return gl.sealer.seal(plain)
+}
+
+// encode returns the data to store in the backend for record, signed and encrypted if the lock has a secret
+func (gl *gistLock) encode(record lockRecord) (data []byte, err error) {
+	data, err = json.Marshal(record)
 	if err != nil {
 		return
 	}
 
-	recordBytes, err = json.Marshal(*record)
+	return gl.seal(data)
+}
+
+// get returns the current lock record and the backend version it was read at
+func (gl *gistLock) get(ctx context.Context) (record *lockRecord, version string, err error) {
+	data, version, err := gl.backendGet(ctx)
+	if err != nil {
+		return
+	}
+
+	record, err = gl.decode(data)
 	if err != nil {
 		return
 	}
 
-	// add a little random delay of up to 100 milliseconds to prevent race conditions
-	delay := time.Duration(100 * rand.Float64())
-	time.Sleep(delay * time.Millisecond)
+	gl.observe(version)
 	return
 }
 
-// Create attempts to create a LeaderElectionRecord
-func (gl *gistLock) Create(ctx context.Context, ler resourcelock.LeaderElectionRecord) (err error) {
-	return gl.Update(ctx, ler)
+// write writes the record with its fencing token and history on top of old (nil if there is no valid record)
+// if nobody else updated it since version
+func (gl *gistLock) write(ctx context.Context, old *lockRecord, ler resourcelock.LeaderElectionRecord, token int64, version string) (err error) {
+	record := lockRecord{LeaderElectionRecord: ler, FencingToken: token}
+	if ler.HolderIdentity == gl.identity {
+		record.Metadata = gl.options.Metadata
+	}
+	record.History = nextHistory(old, record, gl.options.HistorySize)
+
+	err = gl.put(ctx, record, version)
+	if err != nil {
+		return
+	}
+
+	leader := ler.HolderIdentity == gl.identity
+	if leader {
+		gl.token.Store(token)
+	} else {
+		gl.token.Store(0)
+	}
+
+	gl.options.Metrics.setLeader(leader)
+	if leader && (old == nil || old.HolderIdentity != gl.identity) {
+		gl.options.Metrics.observeTransition()
+	}
+	return
 }
 
-// Update will update an existing LeaderElectionRecord if not held by another actor
-func (gl *gistLock) Update(ctx context.Context, ler resourcelock.LeaderElectionRecord) (err error) {
-	oldLer, _, err := gl.Get(ctx)
+// put writes record as it is if nobody else updated it since version
+func (gl *gistLock) put(ctx context.Context, record lockRecord, version string) (err error) {
+	recordBytes, err := gl.encode(record)
 	if err != nil {
 		return
 	}
 
-	// If lock is held by another actor and has not expired yet return an error
-	if oldLer.HolderIdentity != ler.HolderIdentity {
-		now := time.Now()
-		leaseDuration := time.Duration(oldLer.LeaseDurationSeconds) * time.Second
-		validUntil := oldLer.RenewTime.Add(leaseDuration)
-		leaseStillValid := validUntil.After(now)
-		if leaseStillValid {
-			qualifiedResource := schema.GroupResource{
-				Group:    "coordination.k8s.io",
-				Resource: "Lease",
-			}
-			err = errors.NewConflict(qualifiedResource, gl.gistId, pkgerrors.New("lease is still valid"))
-			return
-		}
+	newVersion, err := gl.backendUpdate(ctx, recordBytes, version, time.Duration(record.LeaseDurationSeconds)*time.Second)
+	if err != nil {
+		return
 	}
+	gl.observeWrite(newVersion)
+	return
+}
+
+// Get returns the LeaderElectionRecord
+func (gl *gistLock) Get(ctx context.Context) (record *resourcelock.LeaderElectionRecord, recordBytes []byte, err error) {
+	defer func() { gl.options.Metrics.observeAttempt(operationGet, err) }()
 
-	// Update lock
-	recordBytes, err := json.Marshal(ler)
+	lr, version, err := gl.get(ctx)
 	if err != nil {
+		err = gl.toAPIError(err)
 		return
 	}
 
-	err = gl.cli.Update(gl.gistId, string(recordBytes))
+	if lr.HolderIdentity != gl.identity {
+		gl.options.Metrics.setLeader(false)
+		if gl.options.AskForLock {
+			gl.contend(ctx, lr, version)
+		}
+	}
+
+	record = &lr.LeaderElectionRecord
+	recordBytes, err = json.Marshal(*record)
+	return
+}
+
+// Create attempts to create a LeaderElectionRecord
+//
+// Like the built-in LeaseLock it fails with AlreadyExists if there is a valid record already.
+// A malformed record (e.g. the initial content of a new gist) is replaced.
+func (gl *gistLock) Create(ctx context.Context, ler resourcelock.LeaderElectionRecord) (err error) {
+	defer func() { gl.options.Metrics.observeAttempt(operationAcquire, err) }()
+
+	err = gl.checkEligible(ctx)
 	if err != nil {
 		return
 	}
 
-	// If the lock was held by another leader ,wait for half of the renew period
-	// and check if the leader has changed or not.
-	// If the leader has changed then let them be the leader by returning an eror
-	// However, if we are still the leader then update again to refresh the lease
-	var curLer *resourcelock.LeaderElectionRecord
-	if len(oldLer.HolderIdentity) == 0 || oldLer.HolderIdentity != ler.HolderIdentity {
-		leaseDuration := time.Duration(ler.LeaseDurationSeconds) * time.Second
-		time.Sleep(leaseDuration - time.Second)
-		// Check current holder
-		curLer, _, err = gl.Get(ctx)
-		if err != nil {
+	data, version, err := gl.backendGet(ctx)
+	if err != nil && !pkgerrors.Is(err, ErrNotFound) {
+		err = gl.toAPIError(err)
+		return
+	}
+
+	if err == nil {
+		_, err = gl.decode(data)
+		if err == nil {
+			err = errors.NewAlreadyExists(qualifiedResource, gl.backend.Describe())
 			return
 		}
 
-		if curLer.HolderIdentity != ler.HolderIdentity {
-			qualifiedResource := schema.GroupResource{
-				Group:    "coordination.k8s.io",
-				Resource: "Lease",
-			}
-			err = errors.NewConflict(qualifiedResource, gl.gistId, pkgerrors.New("there is a new leader"))
+		// Only a malformed record is replaced, not a tampered one
+		if !isMalformed(err) {
+			err = gl.toAPIError(err)
 			return
 		}
+	}
 
-		// Update renew time
-		ler.RenewTime = metav1.Time{time.Now()}
+	// A new record starts a new sequence of fencing tokens, unless the backend remembers where the last one ended
+	var seq int64
+	if sequencer, ok := gl.backend.(Sequencer); ok {
+		seq, err = sequencer.Sequence(ctx)
+		if err != nil {
+			err = gl.toAPIError(err)
+			return
+		}
+	}
+
+	// If someone else created the record first, report it the same way as an existing record
+	err = gl.write(ctx, nil, ler, seq+1, version)
+	if pkgerrors.Is(err, ErrConflict) {
+		err = errors.NewAlreadyExists(qualifiedResource, gl.backend.Describe())
+		return
+	}
+	err = gl.toAPIError(err)
+	return
+}
+
+// Update will update an existing LeaderElectionRecord if not held by another actor
+func (gl *gistLock) Update(ctx context.Context, ler resourcelock.LeaderElectionRecord) (err error) {
+	// Until we know the current holder, assume we renew if we hold the lock
+	operation := operationAcquire
+	if gl.FencingToken() != 0 {
+		operation = operationRenew
+	}
+	defer func() {
+		gl.options.Metrics.observeAttempt(operation, err)
+		// Until a renewal succeeds, we can't tell whether we still hold the lock
+		if err != nil && operation == operationRenew {
+			gl.options.Metrics.setLeader(false)
+		}
+	}()
+
+	oldLer, version, err := gl.get(ctx)
+	if err != nil {
+		err = gl.toAPIError(err)
+		return
+	}
+
+	switch {
+	case ler.HolderIdentity == "":
+		operation = operationRelease
+	case ler.HolderIdentity == oldLer.HolderIdentity:
+		operation = operationRenew
+	default:
+		operation = operationAcquire
+	}
+
+	switch {
+	case ler.HolderIdentity == "" && oldLer.HolderIdentity != "" && oldLer.HolderIdentity != gl.identity:
+		// Only the holder can release the lock (see the Inspector for forced releases)
+		err = errors.NewConflict(qualifiedResource, gl.backend.Describe(),
+			pkgerrors.Errorf("lock is held by %s", oldLer.HolderIdentity))
+		return
+	case ler.HolderIdentity == "":
+		// Our own release (e.g. ReleaseOnCancel) is written right away, so the next leader doesn't wait for our lease
+	case oldLer.HolderIdentity == gl.identity && gl.yields(oldLer, version):
+		// We stop renewing, so a contender in a more preferred region takes over when our lease expires
+		err = errors.NewConflict(qualifiedResource, gl.backend.Describe(),
+			pkgerrors.Errorf("yielding to %s in the preferred region %s", oldLer.Contender.Identity, oldLer.Contender.Region))
+		return
+	case oldLer.HolderIdentity != ler.HolderIdentity && !gl.mayTakeOver(oldLer, version):
+		// The lock is held by another actor and has not expired yet (or more preferred instances go first)
+		err = gl.leaseStillValid()
+		return
+	}
 
-		// Update LER again
-		ler.RenewTime = metav1.Time{time.Now()}
-		err = gl.Update(ctx, ler)
+	if operation == operationAcquire {
+		err = gl.checkEligible(ctx)
+		if err != nil {
+			return
+		}
 	}
 
+	// Update lock only if nobody else updated it since we read it.
+	// If someone did, fail fast with a Conflict and let the leader election retry.
+	err = gl.write(ctx, oldLer, ler, nextFencingToken(*oldLer, ler.HolderIdentity), version)
+	err = gl.toAPIError(err)
 	return
 }
 
-// RecordEvent is used to record events. Not used by gist lock
-func (gl *gistLock) RecordEvent(string) {
+// RecordEvent is used to record events. It emits a Kubernetes Event if the lock has an EventRecorder
+func (gl *gistLock) RecordEvent(s string) {
+	if gl.options.EventRecorder == nil {
+		return
+	}
 
+	subject := eventSubject(gl.options.EventNamespace, gl.options.EventName)
+	gl.options.EventRecorder.Eventf(subject, corev1.EventTypeNormal, "LeaderElection", "%v %v (%s)", gl.identity, s, gl.backend.Describe())
 }
 
 // Identity will return the locks Identity
@@ -149,20 +512,100 @@ func (gl *gistLock) Identity() string {
 // Describe is used to convert details on current resource lock
 // into a string
 func (gl *gistLock) Describe() string {
-	return "Github gist lock: " + gl.identity
+	return gl.backend.Describe() + " lock: " + gl.identity
 }
 
-func NewGistLock(identity string, gistId string, accessToken string) (lock resourcelock.Interface, err error) {
-	cli := NewGistClient(accessToken)
-	_, err = cli.Get(gistId)
-	if err != nil {
+// FencingToken returns the fencing token of our current leadership or 0 if we don't hold the lock
+func (gl *gistLock) FencingToken() int64 {
+	return gl.token.Load()
+}
+
+// NewLock returns a multi-cluster lock that keeps its record in backend
+func NewLock(identity string, backend Backend) (lock resourcelock.Interface, err error) {
+	return NewLockWithOptions(identity, backend, LockOptions{})
+}
+
+// NewLockWithOptions returns a multi-cluster lock that keeps its record in backend and records its transitions
+func NewLockWithOptions(identity string, backend Backend, options LockOptions) (lock resourcelock.Interface, err error) {
+	if backend == nil {
+		err = pkgerrors.New("backend can't be nil")
+		return
+	}
+
+	if options.HistorySize < 0 {
+		err = pkgerrors.New("history size can't be negative")
+		return
+	}
+	if options.EventNamespace == "" {
+		options.EventNamespace = defaultEventNamespace
+	}
+	if options.EventName == "" {
+		options.EventName = defaultEventName
+	}
+
+	if options.SkewAllowance < 0 {
+		err = pkgerrors.New("skew allowance can't be negative")
+		return
+	}
+	if options.Clock == nil {
+		options.Clock = clock.RealClock{}
+	}
+
+	if options.TakeoverDelay < 0 {
+		err = pkgerrors.New("takeover delay can't be negative")
+		return
+	}
+	if options.AskForLock && len(options.PreferredRegions) == 0 {
+		err = pkgerrors.New("asking for the lock requires preferred regions")
+		return
+	}
+
+	var s *sealer
+	if len(options.Secret) > 0 {
+		s, err = newSealer(options.Secret, options.Encrypt)
+		if err != nil {
+			return
+		}
+	} else if options.Encrypt {
+		err = pkgerrors.New("encryption requires a secret")
 		return
 	}
 
 	lock = &gistLock{
 		identity: identity,
-		gistId:   gistId,
-		cli:      cli,
+		backend:  backend,
+		options:  options,
+		sealer:   s,
+		clock:    options.Clock,
 	}
 	return
 }
+
+// NewGistLock returns a multi-cluster lock that keeps its record in the file filename of the gist gistId.
+//
+// Use a different filename for each workload to host many independent locks in one gist.
+func NewGistLock(identity string, gistId string, filename string, accessToken string) (lock resourcelock.Interface, err error) {
+	backend, err := NewGistBackend(gistId, filename, accessToken)
+	if err != nil {
+		return
+	}
+
+	return NewLock(identity, backend)
+}
+
+// NewGistLockWithOptions returns a multi-cluster lock with options that keeps its record in the file filename of the gist gistId.
+//
+// The options.Metrics record the requests of the gist client as well.
+func NewGistLockWithOptions(identity string, gistId string, filename string, accessToken string, options LockOptions) (lock resourcelock.Interface, err error) {
+	cli, err := NewGistClientWithOptions(accessToken, GistClientOptions{Metrics: options.Metrics})
+	if err != nil {
+		return
+	}
+
+	backend, err := NewGistBackendWithClient(gistId, filename, cli)
+	if err != nil {
+		return
+	}
+
+	return NewLockWithOptions(identity, backend, options)
+}
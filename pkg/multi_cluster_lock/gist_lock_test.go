@@ -0,0 +1,399 @@
+package multi_cluster_lock
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	clocktesting "k8s.io/utils/clock/testing"
+)
+
+func newRecord(holder string, renewTime time.Time) resourcelock.LeaderElectionRecord {
+	return resourcelock.LeaderElectionRecord{
+		HolderIdentity:       holder,
+		LeaseDurationSeconds: 1,
+		AcquireTime:          metav1.Time{Time: renewTime},
+		RenewTime:            metav1.Time{Time: renewTime},
+	}
+}
+
+// newTestLock returns a lock of identity on backend
+func newTestLock(backend Backend, identity string, options LockOptions) *gistLock {
+	lock, err := NewLockWithOptions(identity, backend, options)
+	Ω(err).Should(BeNil())
+	return lock.(*gistLock)
+}
+
+// newTestClock returns a fake clock at the current second
+func newTestClock() *clocktesting.FakePassiveClock {
+	return clocktesting.NewFakePassiveClock(time.Now().Truncate(time.Second))
+}
+
+// expireLease lets lock see the lease of the current record (see newRecord) expire by clock
+func expireLease(lock resourcelock.Interface, clock *clocktesting.FakePassiveClock) {
+	_, _, err := lock.Get(context.Background())
+	Ω(err).Should(BeNil())
+	clock.SetTime(clock.Now().Add(2 * time.Second))
+}
+
+var _ = Describe("Lock", func() {
+	ctx := context.Background()
+	var backend Backend
+	var fakeClock *clocktesting.FakePassiveClock
+	var lock resourcelock.Interface
+
+	seed := func(ler resourcelock.LeaderElectionRecord) {
+		data, err := json.Marshal(ler)
+		Ω(err).Should(BeNil())
+		_, err = backend.Update(ctx, data, "")
+		Ω(err).Should(BeNil())
+	}
+
+	BeforeEach(func() {
+		backend = NewMemoryBackend()
+		fakeClock = newTestClock()
+		lock = newTestLock(backend, "me", LockOptions{Clock: fakeClock})
+	})
+
+	It("should fail without a backend", func() {
+		_, err := NewLock("me", nil)
+		Ω(err).ShouldNot(BeNil())
+	})
+
+	It("should describe itself", func() {
+		Ω(lock.Identity()).Should(Equal("me"))
+		Ω(lock.Describe()).Should(Equal("In-memory lock: me"))
+	})
+
+	It("should return NotFound if there is no record", func() {
+		_, _, err := lock.Get(ctx)
+		Ω(errors.IsNotFound(err)).Should(BeTrue())
+	})
+
+	It("should get the current record", func() {
+		seed(newRecord("other", time.Now()))
+
+		ler, data, err := lock.Get(ctx)
+		Ω(err).Should(BeNil())
+		Ω(ler.HolderIdentity).Should(Equal("other"))
+
+		var decoded resourcelock.LeaderElectionRecord
+		err = json.Unmarshal(data, &decoded)
+		Ω(err).Should(BeNil())
+		Ω(decoded.HolderIdentity).Should(Equal("other"))
+	})
+
+	It("should create the record if there is none", func() {
+		err := lock.Create(ctx, newRecord("me", time.Now()))
+		Ω(err).Should(BeNil())
+
+		ler, _, err := lock.Get(ctx)
+		Ω(err).Should(BeNil())
+		Ω(ler.HolderIdentity).Should(Equal("me"))
+	})
+
+	It("should not create the record if a valid one exists", func() {
+		seed(newRecord("other", time.Now().Add(-time.Minute)))
+
+		err := lock.Create(ctx, newRecord("me", time.Now()))
+		Ω(errors.IsAlreadyExists(err)).Should(BeTrue())
+
+		ler, _, err := lock.Get(ctx)
+		Ω(err).Should(BeNil())
+		Ω(ler.HolderIdentity).Should(Equal("other"))
+	})
+
+	It("should replace a malformed record on create", func() {
+		_, err := backend.Update(ctx, []byte("not a record"), "")
+		Ω(err).Should(BeNil())
+
+		err = lock.Create(ctx, newRecord("me", time.Now()))
+		Ω(err).Should(BeNil())
+
+		ler, _, err := lock.Get(ctx)
+		Ω(err).Should(BeNil())
+		Ω(ler.HolderIdentity).Should(Equal("me"))
+	})
+
+	It("should report a concurrent create as AlreadyExists", func() {
+		racer, err := json.Marshal(newRecord("other", time.Now()))
+		Ω(err).Should(BeNil())
+
+		lock, err = NewLock("me", &racingBackend{Backend: backend, racer: racer})
+		Ω(err).Should(BeNil())
+
+		err = lock.Create(ctx, newRecord("me", time.Now()))
+		Ω(errors.IsAlreadyExists(err)).Should(BeTrue())
+	})
+
+	It("should renew a lease it holds", func() {
+		seed(newRecord("me", time.Now()))
+
+		renewTime := time.Now().Add(time.Second).Truncate(time.Second)
+		err := lock.Update(ctx, newRecord("me", renewTime))
+		Ω(err).Should(BeNil())
+
+		ler, _, err := lock.Get(ctx)
+		Ω(err).Should(BeNil())
+		Ω(ler.HolderIdentity).Should(Equal("me"))
+		Ω(ler.RenewTime.Time.Equal(renewTime)).Should(BeTrue())
+	})
+
+	It("should not take over a valid lease held by another actor", func() {
+		seed(newRecord("other", time.Now().Add(time.Minute)))
+
+		err := lock.Update(ctx, newRecord("me", time.Now()))
+		Ω(errors.IsConflict(err)).Should(BeTrue())
+
+		ler, _, err := lock.Get(ctx)
+		Ω(err).Should(BeNil())
+		Ω(ler.HolderIdentity).Should(Equal("other"))
+	})
+
+	It("should take over a lease it saw expire", func() {
+		seed(newRecord("other", fakeClock.Now()))
+		expireLease(lock, fakeClock)
+
+		err := lock.Update(ctx, newRecord("me", fakeClock.Now()))
+		Ω(err).Should(BeNil())
+
+		ler, _, err := lock.Get(ctx)
+		Ω(err).Should(BeNil())
+		Ω(ler.HolderIdentity).Should(Equal("me"))
+	})
+
+	It("should release a lock it holds right away", func() {
+		seed(newRecord("me", time.Now()))
+
+		err := lock.Update(ctx, newRecord("", time.Now()))
+		Ω(err).Should(BeNil())
+
+		other, err := NewLock("other", backend)
+		Ω(err).Should(BeNil())
+		err = other.Update(ctx, newRecord("other", time.Now()))
+		Ω(err).Should(BeNil())
+
+		ler, _, err := lock.Get(ctx)
+		Ω(err).Should(BeNil())
+		Ω(ler.HolderIdentity).Should(Equal("other"))
+	})
+
+	It("should not release a lock held by another actor", func() {
+		seed(newRecord("other", time.Now().Add(-time.Minute)))
+
+		err := lock.Update(ctx, newRecord("", time.Now()))
+		Ω(errors.IsConflict(err)).Should(BeTrue())
+
+		ler, _, err := lock.Get(ctx)
+		Ω(err).Should(BeNil())
+		Ω(ler.HolderIdentity).Should(Equal("other"))
+	})
+
+	It("should fail with Conflict if the record changed concurrently", func() {
+		seed(newRecord("me", time.Now()))
+		racer, err := json.Marshal(newRecord("other", time.Now()))
+		Ω(err).Should(BeNil())
+
+		lock, err = NewLock("me", &racingBackend{Backend: backend, racer: racer})
+		Ω(err).Should(BeNil())
+
+		err = lock.Update(ctx, newRecord("me", time.Now()))
+		Ω(errors.IsConflict(err)).Should(BeTrue())
+
+		ler, _, err := lock.Get(ctx)
+		Ω(err).Should(BeNil())
+		Ω(ler.HolderIdentity).Should(Equal("other"))
+	})
+})
+
+// racingBackend writes a competing record right before the first Update goes through
+type racingBackend struct {
+	Backend
+	racer []byte
+}
+
+func (b *racingBackend) Update(ctx context.Context, record []byte, version string) (newVersion string, err error) {
+	if b.racer != nil {
+		_, err = b.Backend.Update(ctx, b.racer, version)
+		b.racer = nil
+		if err != nil {
+			return
+		}
+	}
+	return b.Backend.Update(ctx, record, version)
+}
+
+var _ = Describe("Lock on gist backend", func() {
+	ctx := context.Background()
+
+	It("should take over an expired lease without waiting", func() {
+		server := NewFakeGistServer()
+		DeferCleanup(server.Close)
+		fakeClock := newTestClock()
+		data, err := json.Marshal(newRecord("other", fakeClock.Now()))
+		Ω(err).Should(BeNil())
+		server.Put("gist-1", "lock.json", string(data))
+
+		backend, err := NewGistBackendWithClient("gist-1", "lock.json", newTestClient(server))
+		Ω(err).Should(BeNil())
+		lock := newTestLock(backend, "me", LockOptions{Clock: fakeClock})
+		expireLease(lock, fakeClock)
+
+		ler := newRecord("me", fakeClock.Now())
+		ler.LeaseDurationSeconds = 60
+		start := time.Now()
+		err = lock.Update(ctx, ler)
+		Ω(err).Should(BeNil())
+		Ω(time.Since(start)).Should(BeNumerically("<", time.Second))
+
+		cur, _, err := lock.Get(ctx)
+		Ω(err).Should(BeNil())
+		Ω(cur.HolderIdentity).Should(Equal("me"))
+	})
+})
+
+var _ = Describe("Leader election", func() {
+	It("should bootstrap an empty gist and acquire the lock", func() {
+		server := NewFakeGistServer()
+		DeferCleanup(server.Close)
+		server.Put("gist-1", "README.md", "multi-cluster locks")
+
+		backend, err := NewGistBackendWithClient("gist-1", "lock.json", newTestClient(server))
+		Ω(err).Should(BeNil())
+		lock, err := NewLock("me", backend)
+		Ω(err).Should(BeNil())
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		started := make(chan struct{})
+		elector, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+			Lock:          lock,
+			LeaseDuration: 2 * time.Second,
+			RenewDeadline: time.Second,
+			RetryPeriod:   200 * time.Millisecond,
+			Callbacks: leaderelection.LeaderCallbacks{
+				OnStartedLeading: func(context.Context) { close(started) },
+				OnStoppedLeading: func() {},
+			},
+		})
+		Ω(err).Should(BeNil())
+
+		go elector.Run(ctx)
+		Eventually(started, 5*time.Second).Should(BeClosed())
+
+		ler, _, err := lock.Get(ctx)
+		Ω(err).Should(BeNil())
+		Ω(ler.HolderIdentity).Should(Equal("me"))
+	})
+})
+
+var _ = Describe("Multiple locks in one gist", func() {
+	ctx := context.Background()
+
+	It("should not clobber each other", func() {
+		server := NewFakeGistServer()
+		DeferCleanup(server.Close)
+		server.Put("gist-1", "README.md", "locks of all workloads")
+
+		newGistLock := func(identity string, filename string) resourcelock.Interface {
+			backend, err := NewGistBackendWithClient("gist-1", filename, newTestClient(server))
+			Ω(err).Should(BeNil())
+			lock, err := NewLock(identity, backend)
+			Ω(err).Should(BeNil())
+			return lock
+		}
+
+		for _, filename := range []string{"workload-1.json", "workload-2.json"} {
+			data, err := json.Marshal(newRecord("", time.Now()))
+			Ω(err).Should(BeNil())
+			server.Put("gist-1", filename, string(data))
+		}
+
+		lock1 := newGistLock("me", "workload-1.json")
+		lock2 := newGistLock("you", "workload-2.json")
+
+		err := lock1.Update(ctx, newRecord("me", time.Now()))
+		Ω(err).Should(BeNil())
+		err = lock2.Update(ctx, newRecord("you", time.Now()))
+		Ω(err).Should(BeNil())
+
+		ler, _, err := lock1.Get(ctx)
+		Ω(err).Should(BeNil())
+		Ω(ler.HolderIdentity).Should(Equal("me"))
+
+		ler, _, err = lock2.Get(ctx)
+		Ω(err).Should(BeNil())
+		Ω(ler.HolderIdentity).Should(Equal("you"))
+		Ω(server.Content("gist-1", "README.md")).Should(Equal("locks of all workloads"))
+	})
+})
+
+var _ = Describe("Lock error mapping", func() {
+	ctx := context.Background()
+	var server *FakeGistServer
+	var cli *GistClient
+	var lock resourcelock.Interface
+
+	BeforeEach(func() {
+		var err error
+		server = NewFakeGistServer()
+		DeferCleanup(server.Close)
+		data, err := json.Marshal(newRecord("other", time.Now()))
+		Ω(err).Should(BeNil())
+		server.Put("gist-1", "lock.json", string(data))
+
+		cli = newTestClient(server)
+		cli.maxRetries = 0
+		lock, err = NewLock("me", &gistBackend{gistId: "gist-1", filename: "lock.json", cli: cli})
+		Ω(err).Should(BeNil())
+	})
+
+	It("should map a malformed record to NotFound", func() {
+		server.Put("gist-1", "lock.json", "not a record")
+		_, _, err := lock.Get(ctx)
+		Ω(errors.IsNotFound(err)).Should(BeTrue())
+	})
+
+	It("should map unauthorized to Unauthorized", func() {
+		server.Fail(http.StatusUnauthorized, nil)
+		_, _, err := lock.Get(ctx)
+		Ω(errors.IsUnauthorized(err)).Should(BeTrue())
+	})
+
+	It("should map rate limits to TooManyRequests", func() {
+		server.Fail(http.StatusTooManyRequests, http.Header{"Retry-After": []string{"30"}})
+		_, _, err := lock.Get(ctx)
+		Ω(errors.IsTooManyRequests(err)).Should(BeTrue())
+		seconds, ok := errors.SuggestsClientDelay(err)
+		Ω(ok).Should(BeTrue())
+		Ω(seconds).Should(Equal(30))
+	})
+
+	It("should map server errors to InternalError", func() {
+		server.Fail(http.StatusInternalServerError, nil)
+		_, _, err := lock.Get(ctx)
+		Ω(errors.IsInternalError(err)).Should(BeTrue())
+	})
+
+	It("should map a rejected write to an API error", func() {
+		server.Put("gist-1", "lock.json", "{}")
+		transport := cli.cli.Transport
+		cli.cli.Transport = roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			if req.Method == http.MethodPatch {
+				server.Fail(http.StatusForbidden, nil)
+			}
+			return transport.RoundTrip(req)
+		})
+
+		err := lock.Update(ctx, newRecord("me", time.Now()))
+		Ω(errors.IsForbidden(err)).Should(BeTrue())
+	})
+})
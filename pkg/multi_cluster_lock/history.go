@@ -0,0 +1,64 @@
+package multi_cluster_lock
+
+import (
+	"context"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Transition is an entry of the transition history of a lock: who acquired the lock and when.
+//
+// Forced operations of an Inspector are recorded too, along with the operator who performed them and why.
+// A forced release has an empty HolderIdentity.
+type Transition struct {
+	HolderIdentity string      `json:"holderIdentity"`
+	AcquireTime    metav1.Time `json:"acquireTime"`
+	FencingToken   int64       `json:"fencingToken,omitempty"`
+	Operator       string      `json:"operator,omitempty"`
+	Reason         string      `json:"reason,omitempty"`
+}
+
+// nextHistory returns the history of record, which is written on top of old.
+//
+// The history of old is always carried over, so an instance that doesn't keep a history
+// doesn't erase the history kept by the others. A new holder of record is appended if size > 0
+// and only the last size transitions are kept.
+func nextHistory(old *lockRecord, record lockRecord, size int) (history []Transition) {
+	if old != nil {
+		history = append(history, old.History...)
+	}
+
+	acquired := record.HolderIdentity != "" && (old == nil || old.HolderIdentity != record.HolderIdentity)
+	if !acquired || size <= 0 {
+		return
+	}
+
+	history = append(history, Transition{
+		HolderIdentity: record.HolderIdentity,
+		AcquireTime:    record.AcquireTime,
+		FencingToken:   record.FencingToken,
+	})
+	if len(history) > size {
+		history = history[len(history)-size:]
+	}
+	return
+}
+
+// TransitionHistory returns the transition history kept in the lock record of backend, oldest first.
+//
+// The history is empty unless the locks were created with LockOptions.HistorySize.
+// It can't read sealed records (see LockOptions.Secret). Use an Inspector with the options of the lock for those.
+func TransitionHistory(ctx context.Context, backend Backend) (history []Transition, err error) {
+	data, _, err := backend.Get(ctx)
+	if err != nil {
+		return
+	}
+
+	record, err := gistToLockRecord(data)
+	if err != nil {
+		return
+	}
+
+	history = record.History
+	return
+}
@@ -0,0 +1,92 @@
+package multi_cluster_lock
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	clocktesting "k8s.io/utils/clock/testing"
+)
+
+var _ = Describe("Transition history", func() {
+	ctx := context.Background()
+	var backend Backend
+	var fakeClock *clocktesting.FakePassiveClock
+
+	holders := func() (identities []string) {
+		history, err := TransitionHistory(ctx, backend)
+		Ω(err).Should(BeNil())
+		for _, t := range history {
+			identities = append(identities, t.HolderIdentity)
+		}
+		return
+	}
+
+	BeforeEach(func() {
+		backend = NewMemoryBackend()
+		fakeClock = newTestClock()
+	})
+
+	It("should reject a negative size", func() {
+		_, err := NewLockWithOptions("me", backend, LockOptions{HistorySize: -1})
+		Ω(err).ShouldNot(BeNil())
+	})
+
+	It("should not keep a history by default", func() {
+		lock := newTestLock(backend, "me", LockOptions{Clock: fakeClock})
+		err := lock.Create(ctx, newRecord("me", time.Now()))
+		Ω(err).Should(BeNil())
+		Ω(holders()).Should(BeEmpty())
+	})
+
+	It("should record acquisitions but not renewals", func() {
+		me := newTestLock(backend, "me", LockOptions{HistorySize: 10, Clock: fakeClock})
+		other := newTestLock(backend, "other", LockOptions{HistorySize: 10, Clock: fakeClock})
+
+		err := me.Create(ctx, newRecord("me", fakeClock.Now()))
+		Ω(err).Should(BeNil())
+		err = me.Update(ctx, newRecord("me", fakeClock.Now()))
+		Ω(err).Should(BeNil())
+		expireLease(other, fakeClock)
+		err = other.Update(ctx, newRecord("other", fakeClock.Now()))
+		Ω(err).Should(BeNil())
+
+		history, err := TransitionHistory(ctx, backend)
+		Ω(err).Should(BeNil())
+		Ω(history).Should(HaveLen(2))
+		Ω(history[0].HolderIdentity).Should(Equal("me"))
+		Ω(history[0].FencingToken).Should(Equal(int64(1)))
+		Ω(history[1].HolderIdentity).Should(Equal("other"))
+		Ω(history[1].FencingToken).Should(Equal(int64(2)))
+	})
+
+	It("should keep only the last transitions", func() {
+		me := newTestLock(backend, "me", LockOptions{HistorySize: 2, Clock: fakeClock})
+		other := newTestLock(backend, "other", LockOptions{HistorySize: 2, Clock: fakeClock})
+
+		err := me.Create(ctx, newRecord("me", fakeClock.Now()))
+		Ω(err).Should(BeNil())
+		expireLease(other, fakeClock)
+		err = other.Update(ctx, newRecord("other", fakeClock.Now()))
+		Ω(err).Should(BeNil())
+		expireLease(me, fakeClock)
+		err = me.Update(ctx, newRecord("me", fakeClock.Now()))
+		Ω(err).Should(BeNil())
+
+		Ω(holders()).Should(Equal([]string{"other", "me"}))
+	})
+
+	It("should carry over the history when written by a lock without one", func() {
+		me := newTestLock(backend, "me", LockOptions{HistorySize: 10, Clock: fakeClock})
+		other := newTestLock(backend, "other", LockOptions{Clock: fakeClock})
+
+		err := me.Create(ctx, newRecord("me", fakeClock.Now()))
+		Ω(err).Should(BeNil())
+		expireLease(other, fakeClock)
+		err = other.Update(ctx, newRecord("other", fakeClock.Now()))
+		Ω(err).Should(BeNil())
+
+		Ω(holders()).Should(Equal([]string{"me"}))
+	})
+})
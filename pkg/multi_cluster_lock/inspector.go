@@ -0,0 +1,208 @@
+package multi_cluster_lock
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	pkgerrors "github.com/pkg/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+const (
+	// inspectorIdentity is the identity of an Inspector in events
+	inspectorIdentity = "inspector"
+
+	// defaultAuditHistorySize is how many transitions an Inspector keeps when the lock has no history size
+	defaultAuditHistorySize = 10
+)
+
+// LockStatus is a snapshot of a lock record
+type LockStatus struct {
+	HolderIdentity    string          // empty if nobody holds the lock
+	AcquireTime       time.Time       // when the holder acquired the lock
+	RenewTime         time.Time       // when the holder renewed the lock last
+	LeaseDuration     time.Duration   //
+	Remaining         time.Duration   // how long until the lease expires by the RenewTime of the holder. 0 if it expired
+	LeaderTransitions int             // how many times the lock changed hands
+	FencingToken      int64           // the fencing token of the holder
+	History           []Transition    // the transition history, oldest first
+	Metadata          *HolderMetadata // where the holder runs, if it has LockOptions.Metadata
+}
+
+// Audit identifies who performed a forced operation and why. Both are required.
+type Audit struct {
+	Operator string
+	Reason   string
+}
+
+// Inspector reads a lock record and lets operators evict a wedged leader.
+//
+// The forced operations are compare-and-swap writes, so they fail with a Conflict
+// if the record changed since it was read, just like the updates of the lock itself.
+// They are recorded in the transition history of the record and as events if the
+// lock options have an EventRecorder.
+type Inspector interface {
+	// Status returns the current state of the lock
+	Status(ctx context.Context) (status *LockStatus, err error)
+
+	// Release clears the holder of the lock, even if its lease is still valid, so another instance can acquire it right away
+	Release(ctx context.Context, audit Audit) (err error)
+
+	// Steal hands the lock over to holder. It fails with a Conflict if the lease of the current holder is still valid.
+	// The lease runs from the first read of the inspector, whatever the RenewTime says (see LockOptions.SkewAllowance),
+	// so call Status() first and wait LeaseDuration
+	Steal(ctx context.Context, holder string, audit Audit) (err error)
+}
+
+type inspector struct {
+	lock *gistLock
+}
+
+func (in *inspector) Status(ctx context.Context) (status *LockStatus, err error) {
+	record, _, err := in.lock.get(ctx)
+	if err != nil {
+		err = in.lock.toAPIError(err)
+		return
+	}
+
+	leaseDuration := time.Duration(record.LeaseDurationSeconds) * time.Second
+	var remaining time.Duration
+	if record.HolderIdentity != "" {
+		remaining = max(record.RenewTime.Add(leaseDuration).Sub(in.lock.clock.Now()), 0)
+	}
+
+	status = &LockStatus{
+		HolderIdentity:    record.HolderIdentity,
+		AcquireTime:       record.AcquireTime.Time,
+		RenewTime:         record.RenewTime.Time,
+		LeaseDuration:     leaseDuration,
+		Remaining:         remaining,
+		LeaderTransitions: record.LeaderTransitions,
+		FencingToken:      record.FencingToken,
+		History:           record.History,
+		Metadata:          record.Metadata,
+	}
+	return
+}
+
+func (a Audit) validate() (err error) {
+	if a.Operator == "" || a.Reason == "" {
+		err = pkgerrors.New("forced operations require an operator and a reason")
+	}
+	return
+}
+
+// force writes the record that holder gets on top of the current record if nobody changed it in between
+func (in *inspector) force(ctx context.Context, holder string, audit Audit) (err error) {
+	err = audit.validate()
+	if err != nil {
+		return
+	}
+
+	old, version, err := in.lock.get(ctx)
+	if err != nil {
+		err = in.lock.toAPIError(err)
+		return
+	}
+
+	// Stealing follows the rules of Update(): only an expired lease can be taken over
+	now := in.lock.clock.Now()
+	if holder != "" && holder != old.HolderIdentity && in.lock.leaseValid(old, version) {
+		err = in.lock.leaseStillValid()
+		return
+	}
+
+	ler := resourcelock.LeaderElectionRecord{
+		HolderIdentity:       holder,
+		LeaseDurationSeconds: old.LeaseDurationSeconds,
+		AcquireTime:          metav1.Time{Time: now},
+		RenewTime:            metav1.Time{Time: now},
+		LeaderTransitions:    old.LeaderTransitions,
+	}
+	if holder == "" {
+		// Like a release by the leader election, the lease expires right away
+		ler.LeaseDurationSeconds = 1
+		ler.AcquireTime = old.AcquireTime
+	}
+	if holder != old.HolderIdentity {
+		ler.LeaderTransitions++
+	}
+
+	record := lockRecord{
+		LeaderElectionRecord: ler,
+		FencingToken:         nextFencingToken(*old, holder),
+	}
+
+	size := in.lock.options.HistorySize
+	if size == 0 {
+		size = defaultAuditHistorySize
+	}
+	record.History = append(append([]Transition{}, old.History...), Transition{
+		HolderIdentity: holder,
+		AcquireTime:    metav1.Time{Time: now},
+		FencingToken:   record.FencingToken,
+		Operator:       audit.Operator,
+		Reason:         audit.Reason,
+	})
+	if len(record.History) > size {
+		record.History = record.History[len(record.History)-size:]
+	}
+
+	data, err := in.lock.encode(record)
+	if err != nil {
+		return
+	}
+
+	_, err = in.lock.backendUpdate(ctx, data, version, time.Duration(record.LeaseDurationSeconds)*time.Second)
+	if err != nil {
+		err = in.lock.toAPIError(err)
+		return
+	}
+
+	action := "released the lock held by " + old.HolderIdentity
+	if holder != "" {
+		action = fmt.Sprintf("handed the lock held by %s over to %s", old.HolderIdentity, holder)
+	}
+	in.lock.RecordEvent(fmt.Sprintf("%s %s: %s", audit.Operator, action, audit.Reason))
+	return
+}
+
+func (in *inspector) Release(ctx context.Context, audit Audit) (err error) {
+	return in.force(ctx, "", audit)
+}
+
+func (in *inspector) Steal(ctx context.Context, holder string, audit Audit) (err error) {
+	if holder == "" {
+		err = pkgerrors.New("holder can't be empty")
+		return
+	}
+	return in.force(ctx, holder, audit)
+}
+
+// NewInspector returns an Inspector of the lock record in backend.
+//
+// The options are the options of the lock. HistorySize bounds the history the forced operations add to
+// (10 transitions if it is 0) and the EventRecorder records them as events.
+func NewInspector(backend Backend, options LockOptions) (in Inspector, err error) {
+	lock, err := NewLockWithOptions(inspectorIdentity, backend, options)
+	if err != nil {
+		return
+	}
+
+	in = &inspector{
+		lock: lock.(*gistLock),
+	}
+	return
+}
+
+// NewGistInspector returns an Inspector of the lock record in the file filename of the gist gistId
+func NewGistInspector(gistId string, filename string, accessToken string) (in Inspector, err error) {
+	backend, err := NewGistBackend(gistId, filename, accessToken)
+	if err != nil {
+		return
+	}
+
+	return NewInspector(backend, LockOptions{})
+}
@@ -0,0 +1,147 @@
+package multi_cluster_lock
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/tools/record"
+	clocktesting "k8s.io/utils/clock/testing"
+)
+
+var _ = Describe("Inspector", func() {
+	ctx := context.Background()
+	audit := Audit{Operator: "alice", Reason: "wedged leader"}
+	var backend Backend
+	var fakeClock *clocktesting.FakePassiveClock
+	var in Inspector
+
+	acquire := func(identity string, renewTime time.Time) {
+		lock, err := NewLock(identity, backend)
+		Ω(err).Should(BeNil())
+		ler := newRecord(identity, renewTime)
+		ler.LeaseDurationSeconds = 60
+		err = lock.Update(ctx, ler)
+		if errors.IsNotFound(err) {
+			err = lock.Create(ctx, ler)
+		}
+		Ω(err).Should(BeNil())
+	}
+
+	BeforeEach(func() {
+		var err error
+		server := NewFakeGistServer()
+		DeferCleanup(server.Close)
+		server.Put("gist-1", "lock.json", "")
+
+		backend, err = NewGistBackendWithClient("gist-1", "lock.json", newTestClient(server))
+		Ω(err).Should(BeNil())
+		fakeClock = newTestClock()
+		in, err = NewInspector(backend, LockOptions{Clock: fakeClock})
+		Ω(err).Should(BeNil())
+	})
+
+	It("should report NotFound if there is no record", func() {
+		_, err := in.Status(ctx)
+		Ω(errors.IsNotFound(err)).Should(BeTrue())
+	})
+
+	It("should report the status of the lock", func() {
+		renewTime := fakeClock.Now().Add(-10 * time.Second)
+		acquire("me", renewTime)
+
+		status, err := in.Status(ctx)
+		Ω(err).Should(BeNil())
+		Ω(status.HolderIdentity).Should(Equal("me"))
+		Ω(status.RenewTime.Equal(renewTime)).Should(BeTrue())
+		Ω(status.LeaseDuration).Should(Equal(time.Minute))
+		Ω(status.Remaining).Should(BeNumerically("~", 50*time.Second, 2*time.Second))
+		Ω(status.FencingToken).Should(Equal(int64(1)))
+	})
+
+	It("should require an operator and a reason", func() {
+		acquire("me", time.Now())
+
+		err := in.Release(ctx, Audit{Operator: "alice"})
+		Ω(err).ShouldNot(BeNil())
+		err = in.Steal(ctx, "you", Audit{Reason: "wedged leader"})
+		Ω(err).ShouldNot(BeNil())
+		err = in.Steal(ctx, "", audit)
+		Ω(err).ShouldNot(BeNil())
+	})
+
+	It("should release a valid lease and record who did it", func() {
+		acquire("me", time.Now())
+
+		err := in.Release(ctx, audit)
+		Ω(err).Should(BeNil())
+
+		status, err := in.Status(ctx)
+		Ω(err).Should(BeNil())
+		Ω(status.HolderIdentity).Should(BeEmpty())
+		Ω(status.History).Should(HaveLen(1))
+		Ω(status.History[0].Operator).Should(Equal("alice"))
+		Ω(status.History[0].Reason).Should(Equal("wedged leader"))
+
+		// Another instance can acquire the lock once the released lease expires
+		Eventually(func() error {
+			lock, err := NewLock("you", backend)
+			Ω(err).Should(BeNil())
+			return lock.Update(ctx, newRecord("you", time.Now()))
+		}, 3*time.Second, 100*time.Millisecond).Should(Succeed())
+	})
+
+	It("should not steal a valid lease", func() {
+		acquire("me", time.Now())
+
+		err := in.Steal(ctx, "you", audit)
+		Ω(errors.IsConflict(err)).Should(BeTrue())
+
+		status, err := in.Status(ctx)
+		Ω(err).Should(BeNil())
+		Ω(status.HolderIdentity).Should(Equal("me"))
+	})
+
+	It("should steal an expired lease with a new fencing token", func() {
+		acquire("me", fakeClock.Now())
+
+		// The inspector has to see the lease expire by its own clock
+		_, err := in.Status(ctx)
+		Ω(err).Should(BeNil())
+		fakeClock.SetTime(fakeClock.Now().Add(time.Minute + time.Second))
+		err = in.Steal(ctx, "you", audit)
+		Ω(err).Should(BeNil())
+
+		status, err := in.Status(ctx)
+		Ω(err).Should(BeNil())
+		Ω(status.HolderIdentity).Should(Equal("you"))
+		Ω(status.FencingToken).Should(Equal(int64(2)))
+		Ω(status.LeaderTransitions).Should(Equal(1))
+		Ω(status.Remaining).Should(BeNumerically(">", 0))
+	})
+
+	It("should fail with Conflict if the record changed concurrently", func() {
+		acquire("me", time.Now())
+		racer, err := json.Marshal(newRecord("you", time.Now()))
+		Ω(err).Should(BeNil())
+
+		in, err := NewInspector(&racingBackend{Backend: backend, racer: racer}, LockOptions{})
+		Ω(err).Should(BeNil())
+		err = in.Release(ctx, audit)
+		Ω(errors.IsConflict(err)).Should(BeTrue())
+	})
+
+	It("should record forced operations as events", func() {
+		recorder := record.NewFakeRecorder(10)
+		in, err := NewInspector(backend, LockOptions{EventRecorder: recorder})
+		Ω(err).Should(BeNil())
+		acquire("me", time.Now())
+
+		err = in.Release(ctx, audit)
+		Ω(err).Should(BeNil())
+		Ω(recorder.Events).Should(Receive(ContainSubstring("inspector alice released the lock held by me: wedged leader")))
+	})
+})
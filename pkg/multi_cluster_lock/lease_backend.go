@@ -0,0 +1,125 @@
+package multi_cluster_lock
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/pkg/errors"
+	"github.com/the-gigi/go-k8s/pkg/client"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+const (
+	// recordAnnotation holds the raw lock record on the Lease
+	recordAnnotation = "multi-cluster-lock.go-k8s.io/record"
+)
+
+// leaseBackend keeps the lock record in a coordination.k8s.io/v1 Lease on an arbiter cluster.
+//
+// The arbiter is a Kubernetes cluster separate from the member clusters that run the workload.
+// The raw record is stored in an annotation of the Lease and the version of the record is the
+// resourceVersion of the Lease, so writes use the optimistic concurrency of the API server.
+// The Lease spec mirrors the record, so `kubectl get lease` shows the current holder.
+type leaseBackend struct {
+	cli       client.Clientset
+	namespace string
+	name      string
+}
+
+func (b *leaseBackend) Get(ctx context.Context) (record []byte, version string, err error) {
+	lease, err := b.cli.CoordinationV1().Leases(b.namespace).Get(ctx, b.name, metav1.GetOptions{})
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			err = ErrNotFound
+		}
+		return
+	}
+
+	version = lease.ResourceVersion
+	data, ok := lease.Annotations[recordAnnotation]
+	if ok {
+		record = []byte(data)
+		return
+	}
+
+	// The Lease was not created by us. Use its spec if it has a holder
+	if lease.Spec.HolderIdentity == nil {
+		err = ErrNotFound
+		return
+	}
+
+	record, err = json.Marshal(resourcelock.LeaseSpecToLeaderElectionRecord(&lease.Spec))
+	return
+}
+
+func (b *leaseBackend) Update(ctx context.Context, record []byte, version string) (newVersion string, err error) {
+	lease := &coordinationv1.Lease{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            b.name,
+			Namespace:       b.namespace,
+			ResourceVersion: version,
+			Annotations:     map[string]string{recordAnnotation: string(record)},
+		},
+	}
+
+	var ler resourcelock.LeaderElectionRecord
+	if json.Unmarshal(record, &ler) == nil {
+		lease.Spec = resourcelock.LeaderElectionRecordToLeaseSpec(&ler)
+	}
+
+	leases := b.cli.CoordinationV1().Leases(b.namespace)
+	if version == "" {
+		lease, err = leases.Create(ctx, lease, metav1.CreateOptions{})
+	} else {
+		lease, err = leases.Update(ctx, lease, metav1.UpdateOptions{})
+	}
+
+	if err != nil {
+		if apierrors.IsAlreadyExists(err) || apierrors.IsConflict(err) || apierrors.IsNotFound(err) {
+			err = ErrConflict
+		}
+		return
+	}
+
+	newVersion = lease.ResourceVersion
+	return
+}
+
+func (b *leaseBackend) Describe() string {
+	return "Lease " + b.namespace + "/" + b.name
+}
+
+// NewLeaseBackend returns a backend that keeps the lock record in the Lease namespace/name
+// on the arbiter cluster reached through kubeConfigPath and kubeContext
+func NewLeaseBackend(kubeConfigPath string, kubeContext string, namespace string, name string) (backend Backend, err error) {
+	cli, err := client.NewClientset(kubeConfigPath, kubeContext)
+	if err != nil {
+		return
+	}
+
+	return NewLeaseBackendWithClientset(cli, namespace, name)
+}
+
+// NewLeaseBackendWithClientset returns a backend that keeps the lock record in the Lease
+// namespace/name on the arbiter cluster cli talks to
+func NewLeaseBackendWithClientset(cli client.Clientset, namespace string, name string) (backend Backend, err error) {
+	if cli == nil {
+		err = errors.New("clientset can't be nil")
+		return
+	}
+
+	if namespace == "" || name == "" {
+		err = errors.New("namespace and name can't be empty")
+		return
+	}
+
+	backend = &leaseBackend{
+		cli:       cli,
+		namespace: namespace,
+		name:      name,
+	}
+	return
+}
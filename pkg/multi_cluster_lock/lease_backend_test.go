@@ -0,0 +1,133 @@
+package multi_cluster_lock
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// newArbiterClientset returns a fake clientset that enforces resourceVersion on Lease updates
+// like a real API server does
+func newArbiterClientset(objects ...runtime.Object) *fake.Clientset {
+	cli := fake.NewSimpleClientset(objects...)
+	leasesGVR := coordinationv1.SchemeGroupVersion.WithResource("leases")
+	cli.PrependReactor("create", "leases", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		lease := action.(k8stesting.CreateAction).GetObject().(*coordinationv1.Lease).DeepCopy()
+		lease.ResourceVersion = "1"
+		err := cli.Tracker().Create(leasesGVR, lease, lease.Namespace)
+		return true, lease, err
+	})
+	cli.PrependReactor("update", "leases", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		lease := action.(k8stesting.UpdateAction).GetObject().(*coordinationv1.Lease).DeepCopy()
+		obj, err := cli.Tracker().Get(leasesGVR, lease.Namespace, lease.Name)
+		if err != nil {
+			return true, nil, err
+		}
+
+		current := obj.(*coordinationv1.Lease)
+		if current.ResourceVersion != lease.ResourceVersion {
+			return true, nil, errors.NewConflict(leasesGVR.GroupResource(), lease.Name, nil)
+		}
+
+		rv, _ := strconv.Atoi(current.ResourceVersion)
+		lease.ResourceVersion = strconv.Itoa(rv + 1)
+		err = cli.Tracker().Update(leasesGVR, lease, lease.Namespace)
+		return true, lease, err
+	})
+	return cli
+}
+
+var _ = Describe("Lease backend", func() {
+	ctx := context.Background()
+
+	Context("backend contract", func() {
+		testBackendContract(func() Backend {
+			backend, err := NewLeaseBackendWithClientset(newArbiterClientset(), "locks", "my-workload")
+			Ω(err).Should(BeNil())
+			return backend
+		})
+	})
+
+	It("should validate its arguments", func() {
+		_, err := NewLeaseBackendWithClientset(nil, "locks", "my-workload")
+		Ω(err).ShouldNot(BeNil())
+
+		_, err = NewLeaseBackendWithClientset(newArbiterClientset(), "", "my-workload")
+		Ω(err).ShouldNot(BeNil())
+	})
+
+	It("should mirror the record in the Lease spec", func() {
+		cli := newArbiterClientset()
+		backend, err := NewLeaseBackendWithClientset(cli, "locks", "my-workload")
+		Ω(err).Should(BeNil())
+		lock, err := NewLock("me", backend)
+		Ω(err).Should(BeNil())
+
+		err = lock.Create(ctx, newRecord("me", time.Now()))
+		Ω(err).Should(BeNil())
+
+		lease, err := cli.CoordinationV1().Leases("locks").Get(ctx, "my-workload", metav1.GetOptions{})
+		Ω(err).Should(BeNil())
+		Ω(*lease.Spec.HolderIdentity).Should(Equal("me"))
+		Ω(lease.Annotations).Should(HaveKey(recordAnnotation))
+	})
+
+	It("should read a Lease created by someone else", func() {
+		holder := "other"
+		duration := int32(60)
+		cli := newArbiterClientset(&coordinationv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{Name: "my-workload", Namespace: "locks", ResourceVersion: "1"},
+			Spec: coordinationv1.LeaseSpec{
+				HolderIdentity:       &holder,
+				LeaseDurationSeconds: &duration,
+				RenewTime:            &metav1.MicroTime{Time: time.Now()},
+			},
+		})
+		backend, err := NewLeaseBackendWithClientset(cli, "locks", "my-workload")
+		Ω(err).Should(BeNil())
+		lock, err := NewLock("me", backend)
+		Ω(err).Should(BeNil())
+
+		ler, _, err := lock.Get(ctx)
+		Ω(err).Should(BeNil())
+		Ω(ler.HolderIdentity).Should(Equal("other"))
+
+		err = lock.Update(ctx, newRecord("me", time.Now()))
+		Ω(errors.IsConflict(err)).Should(BeTrue())
+	})
+
+	It("should elect a single leader among member clusters", func() {
+		cli := newArbiterClientset()
+		var locks []*gistLock
+		for _, identity := range []string{"cluster-1", "cluster-2", "cluster-3"} {
+			backend, err := NewLeaseBackendWithClientset(cli, "locks", "my-workload")
+			Ω(err).Should(BeNil())
+			lock, err := NewLock(identity, backend)
+			Ω(err).Should(BeNil())
+			locks = append(locks, lock.(*gistLock))
+		}
+
+		acquired := 0
+		for _, lock := range locks {
+			ler := newRecord(lock.Identity(), time.Now())
+			ler.LeaseDurationSeconds = 60
+			err := lock.Create(ctx, ler)
+			if err != nil {
+				err = lock.Update(ctx, ler)
+			}
+			if err == nil {
+				acquired++
+			}
+		}
+		Ω(acquired).Should(Equal(1))
+	})
+})
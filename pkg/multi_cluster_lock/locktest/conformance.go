@@ -0,0 +1,275 @@
+// Package locktest verifies that lock implementations behave the way the multi-cluster leader election expects.
+//
+// It uses Ginkgo and Gomega, so import it from tests only.
+package locktest
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"k8s.io/utils/clock"
+	clocktesting "k8s.io/utils/clock/testing"
+)
+
+// conformanceLeaseDuration is the lease duration of the records the conformance suite writes
+const conformanceLeaseDuration = 10 * time.Second
+
+// LockConformance describes a lock implementation to RunLockConformance()
+type LockConformance struct {
+	// Reset prepares new empty storage for the locks of the next spec. Required
+	Reset func()
+
+	// NewLock returns a lock with identity on the storage prepared by Reset(). The lock must measure
+	// leases with clock, which the suite advances to expire them. Required
+	NewLock func(identity string, clock clock.PassiveClock) (lock resourcelock.Interface, err error)
+
+	// Corrupt stores a malformed record (e.g. what a new gist contains). If nil, the malformed record spec is skipped
+	Corrupt func()
+
+	// SetFailing makes every call to the storage fail while failing is true. If nil, the backend errors spec is skipped
+	SetFailing func(failing bool)
+
+	// SkipRelease skips the release spec for locks that don't support releasing (see LeaderElectionConfig.ReleaseOnCancel)
+	SkipRelease bool
+}
+
+// RunLockConformance adds specs to the current container that verify that a resourcelock.Interface
+// behaves the way the leader election expects and the multi-cluster locks guarantee:
+//
+//   - the first instance creates the record, later ones can't
+//   - the holder renews its lease and nobody else takes over a valid lease
+//   - an expired lease is taken over by the instances that saw it expire (like the leader election, a lock
+//     may measure the lease from its first read, so a new instance doesn't take over on its first read)
+//   - of concurrent contenders at most one wins
+//   - the holder releases the lock so others can take over right away
+//   - a malformed record is reported as NotFound and replaced
+//   - backend errors are reported, but never as NotFound, and leave the record alone
+//
+// Call it inside a Describe() or Context():
+//
+//	var _ = Describe("My lock", func() {
+//		locktest.RunLockConformance(locktest.LockConformance{...})
+//	})
+func RunLockConformance(c LockConformance) {
+	ctx := context.Background()
+	var fakeClock *clocktesting.FakePassiveClock
+
+	newLock := func(identity string) resourcelock.Interface {
+		lock, err := c.NewLock(identity, fakeClock)
+		Ω(err).Should(BeNil())
+		return lock
+	}
+
+	newLer := func(holder string) resourcelock.LeaderElectionRecord {
+		now := metav1.Time{Time: fakeClock.Now()}
+		return resourcelock.LeaderElectionRecord{
+			HolderIdentity:       holder,
+			LeaseDurationSeconds: int(conformanceLeaseDuration.Seconds()),
+			AcquireTime:          now,
+			RenewTime:            now,
+		}
+	}
+
+	// acquire does what the leader election does to acquire or renew the lock
+	acquire := func(lock resourcelock.Interface) (err error) {
+		old, _, err := lock.Get(ctx)
+		if errors.IsNotFound(err) {
+			return lock.Create(ctx, newLer(lock.Identity()))
+		}
+		if err != nil {
+			return
+		}
+
+		ler := newLer(lock.Identity())
+		ler.LeaderTransitions = old.LeaderTransitions
+		if old.HolderIdentity == lock.Identity() {
+			ler.AcquireTime = old.AcquireTime
+		} else {
+			ler.LeaderTransitions++
+		}
+		return lock.Update(ctx, ler)
+	}
+
+	holder := func() string {
+		ler, _, err := newLock("observer").Get(ctx)
+		Ω(err).Should(BeNil())
+		return ler.HolderIdentity
+	}
+
+	BeforeEach(func() {
+		fakeClock = clocktesting.NewFakePassiveClock(time.Now().Truncate(time.Second))
+		c.Reset()
+	})
+
+	It("should report NotFound before the first acquisition", func() {
+		_, _, err := newLock("me").Get(ctx)
+		Ω(errors.IsNotFound(err)).Should(BeTrue())
+	})
+
+	It("should let the first instance create the record", func() {
+		me := newLock("me")
+		Ω(me.Create(ctx, newLer("me"))).Should(Succeed())
+
+		ler, data, err := me.Get(ctx)
+		Ω(err).Should(BeNil())
+		Ω(data).ShouldNot(BeEmpty())
+		Ω(ler.HolderIdentity).Should(Equal("me"))
+		Ω(ler.LeaseDurationSeconds).Should(Equal(int(conformanceLeaseDuration.Seconds())))
+		Ω(ler.RenewTime.Equal(&metav1.Time{Time: fakeClock.Now()})).Should(BeTrue())
+
+		Ω(newLock("other").Create(ctx, newLer("other"))).ShouldNot(Succeed())
+		Ω(holder()).Should(Equal("me"))
+	})
+
+	It("should renew the lease of the holder", func() {
+		me := newLock("me")
+		Ω(acquire(me)).Should(Succeed())
+
+		fakeClock.SetTime(fakeClock.Now().Add(conformanceLeaseDuration / 2))
+		Ω(acquire(me)).Should(Succeed())
+
+		ler, _, err := me.Get(ctx)
+		Ω(err).Should(BeNil())
+		Ω(ler.HolderIdentity).Should(Equal("me"))
+		Ω(ler.RenewTime.Equal(&metav1.Time{Time: fakeClock.Now()})).Should(BeTrue())
+		Ω(ler.LeaderTransitions).Should(BeZero())
+
+		// The lease runs from the renewal
+		fakeClock.SetTime(fakeClock.Now().Add(conformanceLeaseDuration * 3 / 4))
+		Ω(acquire(newLock("other"))).ShouldNot(Succeed())
+		Ω(holder()).Should(Equal("me"))
+	})
+
+	It("should not let anyone take over a valid lease", func() {
+		Ω(acquire(newLock("me"))).Should(Succeed())
+
+		other := newLock("other")
+		Ω(acquire(other)).ShouldNot(Succeed())
+		Ω(holder()).Should(Equal("me"))
+	})
+
+	It("should let another instance take over an expired lease", func() {
+		Ω(acquire(newLock("me"))).Should(Succeed())
+		other := newLock("other")
+		_, _, err := other.Get(ctx)
+		Ω(err).Should(BeNil())
+
+		fakeClock.SetTime(fakeClock.Now().Add(conformanceLeaseDuration + time.Second))
+		Ω(acquire(other)).Should(Succeed())
+
+		ler, _, err := other.Get(ctx)
+		Ω(err).Should(BeNil())
+		Ω(ler.HolderIdentity).Should(Equal("other"))
+		Ω(ler.LeaderTransitions).Should(Equal(1))
+	})
+
+	It("should let at most one of concurrent contenders win", func() {
+		Ω(acquire(newLock("old"))).Should(Succeed())
+
+		const contenders = 5
+		var locks []resourcelock.Interface
+		for _, identity := range []string{"a", "b", "c", "d", "e"} {
+			locks = append(locks, newLock(identity))
+		}
+
+		// Like the leader election, every contender reads the record first
+		olds := make([]*resourcelock.LeaderElectionRecord, contenders)
+		for i, lock := range locks {
+			var err error
+			olds[i], _, err = lock.Get(ctx)
+			Ω(err).Should(BeNil())
+		}
+		fakeClock.SetTime(fakeClock.Now().Add(conformanceLeaseDuration + time.Second))
+
+		var wg sync.WaitGroup
+		var m sync.Mutex
+		var winners []string
+		for i, lock := range locks {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+
+				ler := newLer(lock.Identity())
+				ler.LeaderTransitions = olds[i].LeaderTransitions + 1
+				if lock.Update(ctx, ler) == nil {
+					m.Lock()
+					winners = append(winners, lock.Identity())
+					m.Unlock()
+				}
+			}()
+		}
+		wg.Wait()
+
+		Ω(len(winners)).Should(BeNumerically("<=", 1))
+		if len(winners) == 1 {
+			Ω(holder()).Should(Equal(winners[0]))
+		}
+	})
+
+	It("should let the holder release the lock for an immediate takeover", func() {
+		if c.SkipRelease {
+			Skip("the lock doesn't support releasing")
+		}
+
+		me := newLock("me")
+		Ω(acquire(me)).Should(Succeed())
+
+		// This is the record the leader election writes with ReleaseOnCancel
+		old, _, err := me.Get(ctx)
+		Ω(err).Should(BeNil())
+		now := metav1.Time{Time: fakeClock.Now()}
+		Ω(me.Update(ctx, resourcelock.LeaderElectionRecord{
+			LeaderTransitions:    old.LeaderTransitions,
+			LeaseDurationSeconds: 1,
+			RenewTime:            now,
+			AcquireTime:          now,
+		})).Should(Succeed())
+		Ω(holder()).Should(BeEmpty())
+
+		// The leader election takes over a record without a holder right away
+		Ω(acquire(newLock("other"))).Should(Succeed())
+		Ω(holder()).Should(Equal("other"))
+	})
+
+	It("should report a malformed record as NotFound and replace it", func() {
+		if c.Corrupt == nil {
+			Skip("the storage can't be corrupted")
+		}
+
+		c.Corrupt()
+		me := newLock("me")
+		_, _, err := me.Get(ctx)
+		Ω(errors.IsNotFound(err)).Should(BeTrue())
+
+		Ω(acquire(me)).Should(Succeed())
+		Ω(holder()).Should(Equal("me"))
+	})
+
+	It("should report backend errors but never as NotFound", func() {
+		if c.SetFailing == nil {
+			Skip("the storage can't fail")
+		}
+
+		me := newLock("me")
+		Ω(acquire(me)).Should(Succeed())
+		fakeClock.SetTime(fakeClock.Now().Add(conformanceLeaseDuration + time.Second))
+
+		other := newLock("other")
+		c.SetFailing(true)
+		_, _, err := other.Get(ctx)
+		Ω(err).ShouldNot(BeNil())
+		Ω(errors.IsNotFound(err)).Should(BeFalse())
+		Ω(other.Update(ctx, newLer("other"))).ShouldNot(Succeed())
+		Ω(other.Create(ctx, newLer("other"))).ShouldNot(Succeed())
+
+		c.SetFailing(false)
+		Ω(holder()).Should(Equal("me"))
+		Ω(acquire(me)).Should(Succeed())
+	})
+}
@@ -0,0 +1,60 @@
+package multi_cluster_lock
+
+import (
+	"context"
+	"strconv"
+	"sync"
+)
+
+// memoryBackend keeps the lock record in memory.
+//
+// It is useful for unit tests and for electing a leader between goroutines of the same process.
+type memoryBackend struct {
+	m       sync.Mutex
+	record  []byte
+	version int
+}
+
+func (b *memoryBackend) Get(ctx context.Context) (record []byte, version string, err error) {
+	b.m.Lock()
+	defer b.m.Unlock()
+
+	if b.version == 0 {
+		err = ErrNotFound
+		return
+	}
+
+	record = append([]byte(nil), b.record...)
+	version = strconv.Itoa(b.version)
+	return
+}
+
+func (b *memoryBackend) Update(ctx context.Context, record []byte, version string) (newVersion string, err error) {
+	b.m.Lock()
+	defer b.m.Unlock()
+
+	curVersion := ""
+	if b.version != 0 {
+		curVersion = strconv.Itoa(b.version)
+	}
+
+	if version != curVersion {
+		err = ErrConflict
+		return
+	}
+
+	b.record = append([]byte(nil), record...)
+	b.version++
+	newVersion = strconv.Itoa(b.version)
+	return
+}
+
+func (b *memoryBackend) Describe() string {
+	return "In-memory"
+}
+
+// NewMemoryBackend returns an empty in-memory backend
+func NewMemoryBackend() (backend Backend) {
+	backend = &memoryBackend{}
+	return
+}
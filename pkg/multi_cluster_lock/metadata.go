@@ -0,0 +1,120 @@
+package multi_cluster_lock
+
+import (
+	"context"
+	"time"
+)
+
+// HolderMetadata describes where the holder of a lock runs. The holder stores it in the lock record,
+// so operators can tell which cluster leads (see LockStatus.Metadata)
+type HolderMetadata struct {
+	ClusterName string            `json:"clusterName,omitempty"`
+	Region      string            `json:"region,omitempty"`
+	Priority    int               `json:"priority,omitempty"`
+	Version     string            `json:"version,omitempty"` // e.g. the build version of the holder
+	Labels      map[string]string `json:"labels,omitempty"`
+}
+
+// contender is an instance in a more preferred region than the holder that asks for the lock (see contend())
+type contender struct {
+	Identity string `json:"identity"`
+	Region   string `json:"region,omitempty"`
+}
+
+// rank returns the position of region in the preferred regions of the lock.
+// Regions outside the preferred regions (or no region) rank after all of them.
+func (gl *gistLock) rank(region string) int {
+	preferred := gl.options.PreferredRegions
+	for i, r := range preferred {
+		if r == region {
+			return i
+		}
+	}
+	return len(preferred)
+}
+
+// regionRank returns the position of the region of the lock in its preferred regions
+func (gl *gistLock) regionRank() int {
+	if gl.options.Metadata == nil {
+		return gl.rank("")
+	}
+	return gl.rank(gl.options.Metadata.Region)
+}
+
+// holderRank returns the position of the region of the holder of record in the preferred regions of the lock
+func (gl *gistLock) holderRank(record *lockRecord) int {
+	if record.Metadata == nil {
+		return gl.rank("")
+	}
+	return gl.rank(record.Metadata.Region)
+}
+
+// takeoverDelay returns how long after a lease expired (or was released) the lock may take it over.
+// Each rank in the preferred regions waits another TakeoverDelay, so more preferred instances win the race.
+func (gl *gistLock) takeoverDelay() time.Duration {
+	return time.Duration(gl.regionRank()) * gl.options.TakeoverDelay
+}
+
+// mayTakeOver returns true if the lock may take over record, which is at version and held by another actor
+func (gl *gistLock) mayTakeOver(record *lockRecord, version string) bool {
+	delay := gl.takeoverDelay()
+	if record.HolderIdentity == "" {
+		if delay == 0 {
+			return true
+		}
+
+		// A released record can be taken over after our delay. Like a lease, we measure it from the
+		// release by the clock of the releaser plus the skew allowance and from when we saw it by our clock
+		released := gl.leaseExpiry(record.RenewTime.Time, 0, gl.observedTimeOf(version))
+		return !released.Add(delay).After(gl.clock.Now())
+	}
+
+	return !gl.leaseValidAt(record, version, gl.clock.Now().Add(-delay))
+}
+
+// contend asks the holder of record, which is at version, to hand the lock over to us if we rank before
+// the region of the holder and are eligible. It stores us as the contender in the record, so the holder
+// stops renewing (see yields()) and we take over when its lease expires. The leader election reads the
+// record every retry period but only updates it once the lease expired, so with LockOptions.AskForLock
+// we ask when we read it.
+//
+// Asking is best effort: if the write fails, we ask again on the next read.
+func (gl *gistLock) contend(ctx context.Context, record *lockRecord, version string) {
+	if record.HolderIdentity == "" || !gl.leaseValid(record, version) {
+		return
+	}
+
+	rank := gl.regionRank()
+	if rank >= gl.holderRank(record) {
+		return
+	}
+	if c := record.Contender; c != nil && (c.Identity == gl.identity || gl.rank(c.Region) <= rank) {
+		return
+	}
+
+	if gl.checkEligible(ctx) != nil {
+		return
+	}
+
+	asked := *record
+	asked.Contender = &contender{Identity: gl.identity}
+	if gl.options.Metadata != nil {
+		asked.Contender.Region = gl.options.Metadata.Region
+	}
+	if gl.put(ctx, asked, version) == nil {
+		gl.RecordEvent("asked " + record.HolderIdentity + " for the lock")
+	}
+}
+
+// yields returns true if we hold record, which is at version, and a contender in a more preferred region
+// asked for it. We stop renewing until the contender took over, but only for two lease durations after we
+// saw it ask, so a contender that doesn't take over (e.g. because it crashed) doesn't leave the lock without a leader.
+func (gl *gistLock) yields(record *lockRecord, version string) bool {
+	c := record.Contender
+	if c == nil || gl.rank(c.Region) >= gl.regionRank() {
+		return false
+	}
+
+	leaseDuration := time.Duration(record.LeaseDurationSeconds) * time.Second
+	return gl.observedTimeOf(version).Add(2 * leaseDuration).After(gl.clock.Now())
+}
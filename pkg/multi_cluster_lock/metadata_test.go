@@ -0,0 +1,205 @@
+package multi_cluster_lock
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/api/errors"
+	clocktesting "k8s.io/utils/clock/testing"
+)
+
+var _ = Describe("Holder metadata", func() {
+	ctx := context.Background()
+	var backend Backend
+	var fakeClock *clocktesting.FakePassiveClock
+
+	preferred := []string{"us-east", "us-west"}
+
+	// inRegion returns the options of a lock in region
+	inRegion := func(region string) LockOptions {
+		return LockOptions{
+			Clock:            fakeClock,
+			Metadata:         &HolderMetadata{Region: region},
+			PreferredRegions: preferred,
+			TakeoverDelay:    5 * time.Second,
+		}
+	}
+
+	// observe lets the locks read the current record, which starts their lease timing
+	observe := func(locks ...*gistLock) {
+		for _, lock := range locks {
+			_, _, err := lock.Get(ctx)
+			Ω(err).Should(BeNil())
+		}
+	}
+
+	status := func() *LockStatus {
+		in, err := NewInspector(backend, LockOptions{Clock: fakeClock})
+		Ω(err).Should(BeNil())
+		s, err := in.Status(ctx)
+		Ω(err).Should(BeNil())
+		return s
+	}
+
+	BeforeEach(func() {
+		backend = NewMemoryBackend()
+		fakeClock = newTestClock()
+	})
+
+	It("should store the metadata of the holder in the record", func() {
+		lock := newTestLock(backend, "me", LockOptions{
+			Clock: fakeClock,
+			Metadata: &HolderMetadata{
+				ClusterName: "prod-1",
+				Region:      "us-east",
+				Priority:    10,
+				Version:     "v1.2.3",
+				Labels:      map[string]string{"team": "infra"},
+			},
+		})
+		Ω(lock.Create(ctx, newRecord("me", fakeClock.Now()))).Should(Succeed())
+
+		metadata := status().Metadata
+		Ω(metadata).ShouldNot(BeNil())
+		Ω(metadata.ClusterName).Should(Equal("prod-1"))
+		Ω(metadata.Region).Should(Equal("us-east"))
+		Ω(metadata.Priority).Should(Equal(10))
+		Ω(metadata.Version).Should(Equal("v1.2.3"))
+		Ω(metadata.Labels).Should(Equal(map[string]string{"team": "infra"}))
+
+		// A release doesn't leave the metadata of the former holder behind
+		Ω(lock.Update(ctx, newRecord("", fakeClock.Now()))).Should(Succeed())
+		Ω(status().Metadata).Should(BeNil())
+	})
+
+	It("should let the preferred region take over an expired lease first", func() {
+		Ω(newTestLock(backend, "west", inRegion("us-west")).Create(ctx, newRecord("west", fakeClock.Now()))).Should(Succeed())
+		east := newTestLock(backend, "east", inRegion("us-east"))
+		elsewhere := newTestLock(backend, "elsewhere", inRegion("eu-central"))
+		observe(east, elsewhere)
+
+		// Right after the lease expired only the most preferred region may take over
+		fakeClock.SetTime(fakeClock.Now().Add(2 * time.Second))
+		Ω(elsewhere.Update(ctx, newRecord("elsewhere", fakeClock.Now()))).ShouldNot(Succeed())
+		Ω(east.Update(ctx, newRecord("east", fakeClock.Now()))).Should(Succeed())
+		Ω(status().Metadata.Region).Should(Equal("us-east"))
+	})
+
+	It("should let less preferred regions take over after their delay", func() {
+		Ω(newTestLock(backend, "east", inRegion("us-east")).Create(ctx, newRecord("east", fakeClock.Now()))).Should(Succeed())
+		west := newTestLock(backend, "west", inRegion("us-west"))
+		elsewhere := newTestLock(backend, "elsewhere", inRegion("eu-central"))
+		observe(west, elsewhere)
+
+		// The lease of 1s expired 5s ago: us-west ranks 1 and waits 5s, eu-central ranks 2 and waits 10s
+		fakeClock.SetTime(fakeClock.Now().Add(6 * time.Second))
+		Ω(elsewhere.Update(ctx, newRecord("elsewhere", fakeClock.Now()))).ShouldNot(Succeed())
+		Ω(west.Update(ctx, newRecord("west", fakeClock.Now()))).Should(Succeed())
+		Ω(status().HolderIdentity).Should(Equal("west"))
+	})
+
+	It("should apply the delay to a released lock", func() {
+		west := newTestLock(backend, "west", inRegion("us-west"))
+		Ω(west.Create(ctx, newRecord("west", fakeClock.Now()))).Should(Succeed())
+		Ω(west.Update(ctx, newRecord("", fakeClock.Now()))).Should(Succeed())
+
+		Ω(west.Update(ctx, newRecord("west", fakeClock.Now()))).ShouldNot(Succeed())
+		Ω(newTestLock(backend, "east", inRegion("us-east")).Update(ctx, newRecord("east", fakeClock.Now()))).Should(Succeed())
+	})
+
+	It("should measure the delay of a released lock by its own clock", func() {
+		west := newTestLock(backend, "west", inRegion("us-west"))
+		Ω(west.Create(ctx, newRecord("west", fakeClock.Now()))).Should(Succeed())
+
+		// The clock of the instance that released the lock is 30 seconds behind
+		Ω(west.Update(ctx, newRecord("", fakeClock.Now().Add(-30*time.Second)))).Should(Succeed())
+
+		elsewhere := newTestLock(backend, "elsewhere", inRegion("eu-central"))
+		Ω(elsewhere.Update(ctx, newRecord("elsewhere", fakeClock.Now()))).ShouldNot(Succeed())
+		fakeClock.SetTime(fakeClock.Now().Add(10 * time.Second))
+		Ω(elsewhere.Update(ctx, newRecord("elsewhere", fakeClock.Now()))).Should(Succeed())
+	})
+
+	Context("when a more preferred region asks for the lock", func() {
+		var west, east *gistLock
+		var eastEligible bool
+
+		askedBy := func() *contender {
+			data, _, err := backend.Get(ctx)
+			Ω(err).Should(BeNil())
+			var record lockRecord
+			Ω(json.Unmarshal(data, &record)).Should(Succeed())
+			return record.Contender
+		}
+
+		BeforeEach(func() {
+			eastEligible = true
+			west = newTestLock(backend, "west", inRegion("us-west"))
+			options := inRegion("us-east")
+			options.Eligible = func(context.Context) (bool, error) { return eastEligible, nil }
+			options.AskForLock = true
+			east = newTestLock(backend, "east", options)
+
+			Ω(west.Create(ctx, newRecord("west", fakeClock.Now()))).Should(Succeed())
+		})
+
+		It("should make the holder stop renewing until the contender took over", func() {
+			_, _, err := east.Get(ctx)
+			Ω(err).Should(BeNil())
+			Ω(askedBy()).Should(Equal(&contender{Identity: "east", Region: "us-east"}))
+
+			err = west.Update(ctx, newRecord("west", fakeClock.Now()))
+			Ω(errors.IsConflict(err)).Should(BeTrue())
+			Ω(err).Should(MatchError(ContainSubstring("yielding to east")))
+
+			fakeClock.SetTime(fakeClock.Now().Add(2 * time.Second))
+			Ω(east.Update(ctx, newRecord("east", fakeClock.Now()))).Should(Succeed())
+			Ω(status().HolderIdentity).Should(Equal("east"))
+			Ω(askedBy()).Should(BeNil())
+		})
+
+		It("should let the holder renew again if the contender doesn't take over", func() {
+			_, _, err := east.Get(ctx)
+			Ω(err).Should(BeNil())
+			Ω(west.Update(ctx, newRecord("west", fakeClock.Now()))).ShouldNot(Succeed())
+
+			fakeClock.SetTime(fakeClock.Now().Add(3 * time.Second))
+			Ω(west.Update(ctx, newRecord("west", fakeClock.Now()))).Should(Succeed())
+			Ω(askedBy()).Should(BeNil())
+		})
+
+		It("should not ask for the lock while ineligible or less preferred", func() {
+			eastEligible = false
+			_, _, err := east.Get(ctx)
+			Ω(err).Should(BeNil())
+			Ω(askedBy()).Should(BeNil())
+
+			elsewhere := inRegion("eu-central")
+			elsewhere.AskForLock = true
+			_, _, err = newTestLock(backend, "elsewhere", elsewhere).Get(ctx)
+			Ω(err).Should(BeNil())
+			Ω(askedBy()).Should(BeNil())
+			Ω(west.Update(ctx, newRecord("west", fakeClock.Now()))).Should(Succeed())
+		})
+
+		It("should not write when it reads the record unless it asks for the lock", func() {
+			_, _, err := newTestLock(backend, "other-east", inRegion("us-east")).Get(ctx)
+			Ω(err).Should(BeNil())
+			Ω(askedBy()).Should(BeNil())
+			Ω(west.Update(ctx, newRecord("west", fakeClock.Now()))).Should(Succeed())
+		})
+	})
+
+	It("should reject a negative takeover delay", func() {
+		_, err := NewLockWithOptions("me", backend, LockOptions{TakeoverDelay: -time.Second})
+		Ω(err).ShouldNot(BeNil())
+	})
+
+	It("should require preferred regions to ask for the lock", func() {
+		_, err := NewLockWithOptions("me", backend, LockOptions{AskForLock: true})
+		Ω(err).ShouldNot(BeNil())
+	})
+})
@@ -0,0 +1,178 @@
+package multi_cluster_lock
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/api/errors"
+)
+
+const metricsNamespace = "multi_cluster_lock"
+
+// Operations of a lock and its backend, used as the operation label of the metrics
+const (
+	operationGet     = "get"
+	operationAcquire = "acquire"
+	operationRenew   = "renew"
+	operationRelease = "release"
+	operationUpdate  = "update"
+)
+
+// Metrics are the Prometheus metrics of a multi-cluster lock and its gist client.
+//
+// All the metrics have a lock label with the name passed to NewMetrics(). A nil *Metrics records nothing.
+type Metrics struct {
+	attempts           *prometheus.CounterVec
+	failures           *prometheus.CounterVec
+	backendDuration    *prometheus.HistogramVec
+	gistDuration       *prometheus.HistogramVec
+	rateLimitRemaining prometheus.Gauge
+	isLeader           prometheus.Gauge
+	transitions        prometheus.Counter
+}
+
+// The backends may be slow (e.g. Github), so the buckets go from 10ms to ~20s
+var durationBuckets = prometheus.ExponentialBuckets(0.01, 2, 12)
+
+// NewMetrics creates the metrics of the lock lockName and registers them on registerer.
+//
+// Use a different lockName for every lock registered on the same registerer.
+func NewMetrics(registerer prometheus.Registerer, lockName string) (m *Metrics, err error) {
+	labels := prometheus.Labels{"lock": lockName}
+	metrics := &Metrics{
+		attempts: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   metricsNamespace,
+			Name:        "attempts_total",
+			Help:        "Number of attempts to get, acquire, renew or release the lock.",
+			ConstLabels: labels,
+		}, []string{"operation"}),
+		failures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   metricsNamespace,
+			Name:        "failures_total",
+			Help:        "Number of failed attempts to get, acquire, renew or release the lock by reason.",
+			ConstLabels: labels,
+		}, []string{"operation", "reason"}),
+		backendDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace:   metricsNamespace,
+			Name:        "backend_request_duration_seconds",
+			Help:        "Latency of the requests to the backend of the lock.",
+			ConstLabels: labels,
+			Buckets:     durationBuckets,
+		}, []string{"operation"}),
+		gistDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace:   metricsNamespace,
+			Name:        "gist_request_duration_seconds",
+			Help:        "Latency of the HTTP requests to the Github gist API.",
+			ConstLabels: labels,
+			Buckets:     durationBuckets,
+		}, []string{"method", "code"}),
+		rateLimitRemaining: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   metricsNamespace,
+			Name:        "github_rate_limit_remaining",
+			Help:        "Number of requests remaining in the current Github rate limit window.",
+			ConstLabels: labels,
+		}),
+		isLeader: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   metricsNamespace,
+			Name:        "is_leader",
+			Help:        "1 if this instance holds the lock, 0 otherwise.",
+			ConstLabels: labels,
+		}),
+		transitions: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace:   metricsNamespace,
+			Name:        "transitions_total",
+			Help:        "Number of times this instance acquired the lock (renewals are not counted).",
+			ConstLabels: labels,
+		}),
+	}
+
+	collectors := []prometheus.Collector{
+		metrics.attempts,
+		metrics.failures,
+		metrics.backendDuration,
+		metrics.gistDuration,
+		metrics.rateLimitRemaining,
+		metrics.isLeader,
+		metrics.transitions,
+	}
+	for _, c := range collectors {
+		err = registerer.Register(c)
+		if err != nil {
+			return
+		}
+	}
+
+	m = metrics
+	return
+}
+
+// failureReason returns the reason of a failed lock operation, e.g. Conflict or TooManyRequests
+func failureReason(err error) string {
+	reason := errors.ReasonForError(err)
+	if reason == "" {
+		return "Unknown"
+	}
+	return string(reason)
+}
+
+// observeAttempt records an attempt of a lock operation and its outcome
+func (m *Metrics) observeAttempt(operation string, err error) {
+	if m == nil {
+		return
+	}
+
+	m.attempts.WithLabelValues(operation).Inc()
+	if err != nil {
+		m.failures.WithLabelValues(operation, failureReason(err)).Inc()
+	}
+}
+
+// observeBackend records the latency of a backend request
+func (m *Metrics) observeBackend(operation string, start time.Time) {
+	if m == nil {
+		return
+	}
+
+	m.backendDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+}
+
+// observeGistRequest records the latency of a request to the Gist API and the rate limit Github reports
+func (m *Metrics) observeGistRequest(method string, resp *http.Response, start time.Time) {
+	if m == nil {
+		return
+	}
+
+	code := "error"
+	if resp != nil {
+		code = strconv.Itoa(resp.StatusCode)
+		remaining, err := strconv.Atoi(resp.Header.Get("X-RateLimit-Remaining"))
+		if err == nil {
+			m.rateLimitRemaining.Set(float64(remaining))
+		}
+	}
+	m.gistDuration.WithLabelValues(method, code).Observe(time.Since(start).Seconds())
+}
+
+// setLeader records whether this instance holds the lock
+func (m *Metrics) setLeader(leader bool) {
+	if m == nil {
+		return
+	}
+
+	if leader {
+		m.isLeader.Set(1)
+	} else {
+		m.isLeader.Set(0)
+	}
+}
+
+// observeTransition records that this instance acquired the lock
+func (m *Metrics) observeTransition() {
+	if m == nil {
+		return
+	}
+
+	m.transitions.Inc()
+}
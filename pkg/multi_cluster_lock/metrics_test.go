@@ -0,0 +1,119 @@
+package multi_cluster_lock
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	clocktesting "k8s.io/utils/clock/testing"
+)
+
+var _ = Describe("Metrics", func() {
+	ctx := context.Background()
+	var registry *prometheus.Registry
+	var metrics *Metrics
+	var backend Backend
+	var fakeClock *clocktesting.FakePassiveClock
+	var lock resourcelock.Interface
+
+	BeforeEach(func() {
+		var err error
+		registry = prometheus.NewRegistry()
+		metrics, err = NewMetrics(registry, "my-workload")
+		Ω(err).Should(BeNil())
+
+		backend = NewMemoryBackend()
+		fakeClock = newTestClock()
+		lock = newTestLock(backend, "me", LockOptions{Metrics: metrics, Clock: fakeClock})
+	})
+
+	It("should not register the same lock twice", func() {
+		_, err := NewMetrics(registry, "my-workload")
+		Ω(err).ShouldNot(BeNil())
+
+		_, err = NewMetrics(registry, "other-workload")
+		Ω(err).Should(BeNil())
+	})
+
+	It("should count attempts and failures by reason", func() {
+		_, _, err := lock.Get(ctx)
+		Ω(err).ShouldNot(BeNil())
+
+		err = lock.Create(ctx, newRecord("me", time.Now()))
+		Ω(err).Should(BeNil())
+		err = lock.Update(ctx, newRecord("me", time.Now()))
+		Ω(err).Should(BeNil())
+
+		other, err := NewLockWithOptions("other", backend, LockOptions{Metrics: metrics})
+		Ω(err).Should(BeNil())
+		err = other.Update(ctx, newRecord("other", time.Now()))
+		Ω(err).ShouldNot(BeNil())
+
+		Ω(testutil.ToFloat64(metrics.attempts.WithLabelValues(operationGet))).Should(Equal(1.0))
+		Ω(testutil.ToFloat64(metrics.failures.WithLabelValues(operationGet, "NotFound"))).Should(Equal(1.0))
+		Ω(testutil.ToFloat64(metrics.attempts.WithLabelValues(operationAcquire))).Should(Equal(2.0))
+		Ω(testutil.ToFloat64(metrics.failures.WithLabelValues(operationAcquire, "Conflict"))).Should(Equal(1.0))
+		Ω(testutil.ToFloat64(metrics.attempts.WithLabelValues(operationRenew))).Should(Equal(1.0))
+		Ω(testutil.CollectAndCount(metrics.failures)).Should(Equal(2))
+	})
+
+	It("should track the leadership and the transitions", func() {
+		err := lock.Create(ctx, newRecord("me", fakeClock.Now()))
+		Ω(err).Should(BeNil())
+		Ω(testutil.ToFloat64(metrics.isLeader)).Should(Equal(1.0))
+
+		err = lock.Update(ctx, newRecord("me", fakeClock.Now()))
+		Ω(err).Should(BeNil())
+		Ω(testutil.ToFloat64(metrics.transitions)).Should(Equal(1.0))
+
+		other := newTestLock(backend, "other", LockOptions{Clock: fakeClock})
+		expireLease(other, fakeClock)
+		err = other.Update(ctx, newRecord("other", fakeClock.Now()))
+		Ω(err).Should(BeNil())
+
+		_, _, err = lock.Get(ctx)
+		Ω(err).Should(BeNil())
+		Ω(testutil.ToFloat64(metrics.isLeader)).Should(Equal(0.0))
+
+		Ω(testutil.CollectAndCount(metrics.backendDuration)).Should(Equal(2))
+	})
+
+	It("should stop reporting the leadership when a renewal fails", func() {
+		unavailable := &unavailableBackend{Backend: backend}
+		lock = newTestLock(unavailable, "me", LockOptions{Metrics: metrics, Clock: fakeClock})
+		Ω(lock.Create(ctx, newRecord("me", fakeClock.Now()))).Should(Succeed())
+		Ω(testutil.ToFloat64(metrics.isLeader)).Should(Equal(1.0))
+
+		unavailable.down.Store(true)
+		Ω(lock.Update(ctx, newRecord("me", fakeClock.Now()))).ShouldNot(Succeed())
+		Ω(testutil.ToFloat64(metrics.isLeader)).Should(Equal(0.0))
+
+		unavailable.down.Store(false)
+		Ω(lock.Update(ctx, newRecord("me", fakeClock.Now()))).Should(Succeed())
+		Ω(testutil.ToFloat64(metrics.isLeader)).Should(Equal(1.0))
+		Ω(testutil.ToFloat64(metrics.transitions)).Should(Equal(1.0))
+	})
+
+	It("should record gist requests and the Github rate limit", func() {
+		server := NewFakeGistServer()
+		DeferCleanup(server.Close)
+		server.Put("gist-1", "lock.json", "")
+
+		cli, err := NewGistClientWithOptions("token", GistClientOptions{BaseURL: server.URL, Metrics: metrics})
+		Ω(err).Should(BeNil())
+
+		server.Fail(http.StatusNotFound, http.Header{"X-Ratelimit-Remaining": {"42"}})
+		_, _, err = cli.GetWithVersion(ctx, "gist-1", "lock.json")
+		Ω(err).Should(MatchError(ErrGistNotFound))
+		Ω(testutil.ToFloat64(metrics.rateLimitRemaining)).Should(Equal(42.0))
+
+		_, _, err = cli.GetWithVersion(ctx, "gist-1", "lock.json")
+		Ω(err).Should(BeNil())
+		Ω(testutil.CollectAndCount(metrics.gistDuration)).Should(Equal(2))
+	})
+})
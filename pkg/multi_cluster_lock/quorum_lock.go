@@ -0,0 +1,252 @@
+package multi_cluster_lock
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	pkgerrors "github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/api/errors"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"k8s.io/utils/clock"
+)
+
+// QuorumOptions configures a quorum lock
+type QuorumOptions struct {
+	// SkewAllowance is how far the clocks of the instances may be apart. The lease of a holder of one
+	// of the locks is considered valid for SkewAllowance beyond its RenewTime + LeaseDurationSeconds
+	SkewAllowance time.Duration
+	// Clock is the clock of the lock. Defaults to the real clock. Inject a fake clock in tests
+	Clock clock.PassiveClock
+}
+
+// quorumLock is a lock that is held by whoever holds a majority of its locks.
+//
+// Every operation is sent to all the locks concurrently and succeeds if a majority of them agree.
+type quorumLock struct {
+	locks   []resourcelock.Interface
+	options QuorumOptions
+}
+
+// quorum returns how many locks make a majority
+func (ql *quorumLock) quorum() int {
+	return len(ql.locks)/2 + 1
+}
+
+// each calls f on every lock concurrently and returns the error of each call
+func (ql *quorumLock) each(f func(i int, lock resourcelock.Interface) error) (errs []error) {
+	errs = make([]error, len(ql.locks))
+	var wg sync.WaitGroup
+	for i, lock := range ql.locks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = f(i, lock)
+		}()
+	}
+	wg.Wait()
+	return
+}
+
+// describeErrors returns the errors of the locks that failed, prefixed with the description of the lock
+func (ql *quorumLock) describeErrors(errs []error) error {
+	var failed []error
+	for i, err := range errs {
+		if err != nil {
+			failed = append(failed, pkgerrors.Wrap(err, ql.locks[i].Describe()))
+		}
+	}
+	return utilerrors.NewAggregate(failed)
+}
+
+// Get returns the record of the holder of a majority of the locks.
+//
+// If the locks are reachable but nobody holds a majority of them (e.g. during a takeover, or because
+// a former holder lost some of them), the record has no holder, so the leader election goes on to Update().
+// If a majority of the locks has no record, it returns NotFound, so the leader election calls Create().
+// If a majority of the locks can't be read, it returns ServiceUnavailable.
+func (ql *quorumLock) Get(ctx context.Context) (record *resourcelock.LeaderElectionRecord, recordBytes []byte, err error) {
+	records := make([]*resourcelock.LeaderElectionRecord, len(ql.locks))
+	errs := ql.each(func(i int, lock resourcelock.Interface) (err error) {
+		records[i], _, err = lock.Get(ctx)
+		return
+	})
+
+	notFound := 0
+	held := map[string][]*resourcelock.LeaderElectionRecord{}
+	for i, err := range errs {
+		switch {
+		case errors.IsNotFound(err):
+			notFound++
+		case err == nil && records[i].HolderIdentity != "":
+			held[records[i].HolderIdentity] = append(held[records[i].HolderIdentity], records[i])
+		}
+	}
+
+	available := 0
+	for _, err := range errs {
+		if err == nil || errors.IsNotFound(err) {
+			available++
+		}
+	}
+
+	switch {
+	case notFound >= ql.quorum():
+		err = errors.NewNotFound(qualifiedResource, ql.Describe())
+		return
+	case available < ql.quorum():
+		err = errors.NewServiceUnavailable(fmt.Sprintf("fewer than %d of %d locks are available: %v",
+			ql.quorum(), len(ql.locks), ql.describeErrors(errs)))
+		return
+	}
+
+	record = &resourcelock.LeaderElectionRecord{}
+	for _, agreeing := range held {
+		if len(agreeing) < ql.quorum() {
+			continue
+		}
+
+		// The most recent renewal, so the leader election sees the record change whenever the holder renews
+		*record = *agreeing[0]
+		for _, r := range agreeing[1:] {
+			if r.RenewTime.After(record.RenewTime.Time) {
+				*record = *r
+			}
+		}
+	}
+
+	recordBytes, err = json.Marshal(*record)
+	return
+}
+
+// leaseValid returns true if record is held by someone and their lease hasn't expired
+func (ql *quorumLock) leaseValid(record *resourcelock.LeaderElectionRecord) bool {
+	leaseDuration := time.Duration(record.LeaseDurationSeconds) * time.Second
+	return record.HolderIdentity != "" &&
+		record.RenewTime.Add(leaseDuration+ql.options.SkewAllowance).After(ql.options.Clock.Now())
+}
+
+// write acquires, renews or releases every lock, creating the missing records, and succeeds if it did so on a majority.
+//
+// Every lock is read right before it is written, because some locks (e.g. the client-go LeaseLock) write
+// on top of what they read last and don't check whether the lease of another holder is still valid.
+func (ql *quorumLock) write(ctx context.Context, ler resourcelock.LeaderElectionRecord) (err error) {
+	errs := ql.each(func(i int, lock resourcelock.Interface) (err error) {
+		old, _, err := lock.Get(ctx)
+		if errors.IsNotFound(err) {
+			return lock.Create(ctx, ler)
+		}
+		if err != nil {
+			return
+		}
+
+		// With ReleaseOnCancel, the leader election releases the lock by writing a record without a holder
+		releasing := ler.HolderIdentity == "" && old.HolderIdentity == ql.Identity()
+		if old.HolderIdentity != ler.HolderIdentity && !releasing && ql.leaseValid(old) {
+			return errors.NewConflict(qualifiedResource, lock.Describe(), pkgerrors.Errorf("held by %s", old.HolderIdentity))
+		}
+
+		return lock.Update(ctx, ler)
+	})
+
+	succeeded := 0
+	for _, err := range errs {
+		if err == nil {
+			succeeded++
+		}
+	}
+
+	if succeeded < ql.quorum() {
+		err = errors.NewConflict(qualifiedResource, ql.Describe(),
+			pkgerrors.Errorf("updated %d of %d locks, %d needed: %v", succeeded, len(ql.locks), ql.quorum(), ql.describeErrors(errs)))
+	}
+	return
+}
+
+// Create creates the record on the locks that have none and takes over the others if their leases expired
+func (ql *quorumLock) Create(ctx context.Context, ler resourcelock.LeaderElectionRecord) (err error) {
+	return ql.write(ctx, ler)
+}
+
+// Update updates the record on all the locks that aren't held by someone else, and releases the locks we hold
+func (ql *quorumLock) Update(ctx context.Context, ler resourcelock.LeaderElectionRecord) (err error) {
+	return ql.write(ctx, ler)
+}
+
+// RecordEvent records the event with every lock
+func (ql *quorumLock) RecordEvent(s string) {
+	for _, lock := range ql.locks {
+		lock.RecordEvent(s)
+	}
+}
+
+// Identity returns the identity of the locks
+func (ql *quorumLock) Identity() string {
+	return ql.locks[0].Identity()
+}
+
+// Describe returns the descriptions of all the locks
+func (ql *quorumLock) Describe() string {
+	descriptions := make([]string, len(ql.locks))
+	for i, lock := range ql.locks {
+		descriptions[i] = lock.Describe()
+	}
+	return "quorum of [" + strings.Join(descriptions, ", ") + "]"
+}
+
+// NewQuorumLock returns a lock that is held by whoever holds a majority of locks.
+//
+// See NewQuorumLockWithOptions()
+func NewQuorumLock(locks ...resourcelock.Interface) (lock resourcelock.Interface, err error) {
+	return NewQuorumLockWithOptions(QuorumOptions{}, locks...)
+}
+
+// NewQuorumLockWithOptions returns a lock that is held by whoever holds a majority of locks, Redlock-style.
+//
+// The locks must have the same identity and keep their records in independent backends (e.g. two gists
+// owned by different accounts and a Lease in a Kubernetes cluster), so no single outage takes out a majority.
+// Use an odd number of locks. 3 locks tolerate the failure of 1, 5 locks tolerate 2.
+//
+// Safety: the majorities of two instances always share a lock, and a lock can't be taken over while the lease
+// of its holder is valid, so two instances can't hold a majority within the same lease. Like Redlock, this
+// relies on the lease expiry: an instance that is paused (or whose clock is off by more than SkewAllowance)
+// may act as the leader after its lease expired. Pass the fencing token of one of the locks to downstream
+// systems to protect them from that.
+//
+// Liveness: an instance that took some but not a majority of the locks keeps them until its lease expires.
+// If the candidates split the locks between them, nobody leads until then.
+func NewQuorumLockWithOptions(options QuorumOptions, locks ...resourcelock.Interface) (lock resourcelock.Interface, err error) {
+	if len(locks) == 0 {
+		err = pkgerrors.New("a quorum lock needs at least one lock")
+		return
+	}
+
+	for _, l := range locks {
+		if l == nil {
+			err = pkgerrors.New("locks can't be nil")
+			return
+		}
+		if l.Identity() != locks[0].Identity() {
+			err = pkgerrors.Errorf("all locks must have the same identity, got %s and %s", locks[0].Identity(), l.Identity())
+			return
+		}
+	}
+
+	if options.SkewAllowance < 0 {
+		err = pkgerrors.New("skew allowance can't be negative")
+		return
+	}
+	if options.Clock == nil {
+		options.Clock = clock.RealClock{}
+	}
+
+	lock = &quorumLock{
+		locks:   locks,
+		options: options,
+	}
+	return
+}
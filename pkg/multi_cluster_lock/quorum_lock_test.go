@@ -0,0 +1,294 @@
+package multi_cluster_lock
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	pkgerrors "github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	clocktesting "k8s.io/utils/clock/testing"
+)
+
+var errInjected = pkgerrors.New("injected failure")
+
+// fakeStore is the storage of fake locks. Like a Lease, it stores whatever it is given
+type fakeStore struct {
+	m      sync.Mutex
+	record *resourcelock.LeaderElectionRecord
+	down   bool // if true, every call fails
+}
+
+func (s *fakeStore) setDown(down bool) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.down = down
+}
+
+func (s *fakeStore) holder() string {
+	s.m.Lock()
+	defer s.m.Unlock()
+	if s.record == nil {
+		return ""
+	}
+	return s.record.HolderIdentity
+}
+
+// fakeLock is a resourcelock.Interface on a fakeStore that doesn't check leases, like the client-go LeaseLock
+type fakeLock struct {
+	store    *fakeStore
+	identity string
+}
+
+func (l *fakeLock) Get(_ context.Context) (record *resourcelock.LeaderElectionRecord, recordBytes []byte, err error) {
+	l.store.m.Lock()
+	defer l.store.m.Unlock()
+
+	switch {
+	case l.store.down:
+		err = errors.NewInternalError(errInjected)
+	case l.store.record == nil:
+		err = errors.NewNotFound(qualifiedResource, "fake")
+	default:
+		r := *l.store.record
+		record = &r
+		recordBytes, err = json.Marshal(r)
+	}
+	return
+}
+
+func (l *fakeLock) write(ler resourcelock.LeaderElectionRecord) (err error) {
+	l.store.m.Lock()
+	defer l.store.m.Unlock()
+
+	if l.store.down {
+		return errors.NewInternalError(errInjected)
+	}
+	l.store.record = &ler
+	return
+}
+
+func (l *fakeLock) Create(_ context.Context, ler resourcelock.LeaderElectionRecord) error {
+	return l.write(ler)
+}
+
+func (l *fakeLock) Update(_ context.Context, ler resourcelock.LeaderElectionRecord) error {
+	return l.write(ler)
+}
+
+func (l *fakeLock) RecordEvent(string) {}
+
+func (l *fakeLock) Identity() string {
+	return l.identity
+}
+
+func (l *fakeLock) Describe() string {
+	return "fake lock: " + l.identity
+}
+
+var _ = Describe("Quorum lock", func() {
+	ctx := context.Background()
+	var stores []*fakeStore
+	var fakeClock *clocktesting.FakePassiveClock
+
+	newQuorumLock := func(identity string) resourcelock.Interface {
+		var locks []resourcelock.Interface
+		for _, store := range stores {
+			locks = append(locks, &fakeLock{store: store, identity: identity})
+		}
+		lock, err := NewQuorumLockWithOptions(QuorumOptions{Clock: fakeClock}, locks...)
+		Ω(err).Should(BeNil())
+		return lock
+	}
+
+	newLer := func(holder string) resourcelock.LeaderElectionRecord {
+		return resourcelock.LeaderElectionRecord{
+			HolderIdentity:       holder,
+			LeaseDurationSeconds: 10,
+			AcquireTime:          metav1.Time{Time: fakeClock.Now()},
+			RenewTime:            metav1.Time{Time: fakeClock.Now()},
+		}
+	}
+
+	BeforeEach(func() {
+		stores = []*fakeStore{{}, {}, {}}
+		fakeClock = newTestClock()
+	})
+
+	It("should report NotFound until a majority has a record", func() {
+		me := newQuorumLock("me")
+		_, _, err := me.Get(ctx)
+		Ω(errors.IsNotFound(err)).Should(BeTrue())
+
+		stores[0].record = &resourcelock.LeaderElectionRecord{HolderIdentity: "other"}
+		_, _, err = me.Get(ctx)
+		Ω(errors.IsNotFound(err)).Should(BeTrue())
+	})
+
+	It("should be held by whoever holds a majority", func() {
+		me := newQuorumLock("me")
+		Ω(me.Create(ctx, newLer("me"))).Should(Succeed())
+
+		other := newQuorumLock("other")
+		record, _, err := other.Get(ctx)
+		Ω(err).Should(BeNil())
+		Ω(record.HolderIdentity).Should(Equal("me"))
+
+		err = other.Update(ctx, newLer("other"))
+		Ω(errors.IsConflict(err)).Should(BeTrue())
+		for _, store := range stores {
+			Ω(store.holder()).Should(Equal("me"))
+		}
+	})
+
+	It("should tolerate the failure of a minority", func() {
+		stores[2].setDown(true)
+
+		me := newQuorumLock("me")
+		Ω(me.Create(ctx, newLer("me"))).Should(Succeed())
+		record, _, err := me.Get(ctx)
+		Ω(err).Should(BeNil())
+		Ω(record.HolderIdentity).Should(Equal("me"))
+
+		// The failed lock rejoins
+		stores[2].setDown(false)
+		Ω(me.Update(ctx, newLer("me"))).Should(Succeed())
+		Ω(stores[2].holder()).Should(Equal("me"))
+	})
+
+	It("should fail without a majority", func() {
+		me := newQuorumLock("me")
+		Ω(me.Create(ctx, newLer("me"))).Should(Succeed())
+
+		stores[0].setDown(true)
+		stores[1].setDown(true)
+
+		_, _, err := me.Get(ctx)
+		Ω(errors.IsServiceUnavailable(err)).Should(BeTrue())
+		err = me.Update(ctx, newLer("me"))
+		Ω(errors.IsConflict(err)).Should(BeTrue())
+		Ω(err.Error()).Should(ContainSubstring("updated 1 of 3 locks"))
+	})
+
+	It("should report no holder if nobody holds a majority", func() {
+		stores[0].record = &resourcelock.LeaderElectionRecord{HolderIdentity: "a"}
+		stores[1].record = &resourcelock.LeaderElectionRecord{HolderIdentity: "b"}
+		stores[2].setDown(true)
+
+		record, _, err := newQuorumLock("me").Get(ctx)
+		Ω(err).Should(BeNil())
+		Ω(record.HolderIdentity).Should(BeEmpty())
+	})
+
+	It("should take over the locks whose leases expired", func() {
+		Ω(newQuorumLock("old").Create(ctx, newLer("old"))).Should(Succeed())
+
+		// One lock is still held by a candidate that didn't make it to a majority
+		fakeClock.SetTime(fakeClock.Now().Add(8 * time.Second))
+		ler := newLer("candidate")
+		stores[0].record = &ler
+
+		fakeClock.SetTime(fakeClock.Now().Add(3 * time.Second))
+		me := newQuorumLock("me")
+		Ω(me.Update(ctx, newLer("me"))).Should(Succeed())
+		Ω(stores[0].holder()).Should(Equal("candidate"))
+		Ω(stores[1].holder()).Should(Equal("me"))
+		Ω(stores[2].holder()).Should(Equal("me"))
+
+		record, _, err := me.Get(ctx)
+		Ω(err).Should(BeNil())
+		Ω(record.HolderIdentity).Should(Equal("me"))
+	})
+
+	It("should return the most recent renewal of the holder", func() {
+		me := newQuorumLock("me")
+		Ω(me.Create(ctx, newLer("me"))).Should(Succeed())
+
+		stores[1].setDown(true)
+		fakeClock.SetTime(fakeClock.Now().Add(time.Second))
+		Ω(me.Update(ctx, newLer("me"))).Should(Succeed())
+		stores[1].setDown(false)
+
+		record, _, err := me.Get(ctx)
+		Ω(err).Should(BeNil())
+		Ω(record.RenewTime.Equal(&metav1.Time{Time: fakeClock.Now()})).Should(BeTrue())
+	})
+
+	It("should let the holder release the locks", func() {
+		me := newQuorumLock("me")
+		Ω(me.Create(ctx, newLer("me"))).Should(Succeed())
+
+		// One lock is held by a candidate that didn't make it to a majority
+		ler := newLer("candidate")
+		stores[0].record = &ler
+
+		// This is the record the leader election writes with ReleaseOnCancel
+		Ω(me.Update(ctx, resourcelock.LeaderElectionRecord{
+			LeaseDurationSeconds: 1,
+			AcquireTime:          metav1.Time{Time: fakeClock.Now()},
+			RenewTime:            metav1.Time{Time: fakeClock.Now()},
+		})).Should(Succeed())
+		Ω(stores[0].holder()).Should(Equal("candidate"))
+		Ω(stores[1].holder()).Should(BeEmpty())
+		Ω(stores[2].holder()).Should(BeEmpty())
+
+		other := newQuorumLock("other")
+		Ω(other.Update(ctx, newLer("other"))).Should(Succeed())
+		record, _, err := other.Get(ctx)
+		Ω(err).Should(BeNil())
+		Ω(record.HolderIdentity).Should(Equal("other"))
+	})
+
+	It("should not let anyone else release the locks", func() {
+		Ω(newQuorumLock("me").Create(ctx, newLer("me"))).Should(Succeed())
+
+		Ω(errors.IsConflict(newQuorumLock("other").Update(ctx, resourcelock.LeaderElectionRecord{
+			LeaseDurationSeconds: 1,
+			AcquireTime:          metav1.Time{Time: fakeClock.Now()},
+			RenewTime:            metav1.Time{Time: fakeClock.Now()},
+		}))).Should(BeTrue())
+		for _, store := range stores {
+			Ω(store.holder()).Should(Equal("me"))
+		}
+	})
+
+	It("should combine multi-cluster locks and leases", func() {
+		newLocks := func(identity string, backends []Backend) []resourcelock.Interface {
+			var locks []resourcelock.Interface
+			for _, backend := range backends {
+				locks = append(locks, newTestLock(backend, identity, LockOptions{Clock: fakeClock}))
+			}
+			return locks
+		}
+
+		lease, err := NewLeaseBackendWithClientset(newArbiterClientset(), "locks", "my-workload")
+		Ω(err).Should(BeNil())
+		backends := []Backend{NewMemoryBackend(), NewMemoryBackend(), lease}
+
+		me, err := NewQuorumLockWithOptions(QuorumOptions{Clock: fakeClock}, newLocks("me", backends)...)
+		Ω(err).Should(BeNil())
+		_, _, err = me.Get(ctx)
+		Ω(errors.IsNotFound(err)).Should(BeTrue())
+		Ω(me.Create(ctx, newLer("me"))).Should(Succeed())
+
+		other, err := NewQuorumLockWithOptions(QuorumOptions{Clock: fakeClock}, newLocks("other", backends)...)
+		Ω(err).Should(BeNil())
+		record, _, err := other.Get(ctx)
+		Ω(err).Should(BeNil())
+		Ω(record.HolderIdentity).Should(Equal("me"))
+		Ω(errors.IsConflict(other.Update(ctx, newLer("other")))).Should(BeTrue())
+	})
+
+	It("should validate its locks", func() {
+		_, err := NewQuorumLock()
+		Ω(err).ShouldNot(BeNil())
+
+		_, err = NewQuorumLock(&fakeLock{store: &fakeStore{}, identity: "a"}, &fakeLock{store: &fakeStore{}, identity: "b"})
+		Ω(err).ShouldNot(BeNil())
+	})
+})
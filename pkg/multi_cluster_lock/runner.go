@@ -0,0 +1,270 @@
+package multi_cluster_lock
+
+import (
+	"context"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/util/uuid"
+	"k8s.io/client-go/tools/leaderelection"
+)
+
+const (
+	// Defaults that suit slow backends like Github gists, where a single call may take seconds
+	defaultLeaseDuration  = 60 * time.Second
+	defaultRenewDeadline  = 40 * time.Second
+	defaultRetryPeriod    = 10 * time.Second
+	defaultBackendTimeout = defaultTimeout
+)
+
+// RunnerOptions configures a leader election Runner.
+//
+// Only the Backend is required. The durations default to values that work with Github gists.
+type RunnerOptions struct {
+	Backend     Backend // where the lock record is kept
+	Identity    string  // defaults to <hostname>-<ClusterName>-<uuid>
+	ClusterName string  // the cluster this instance runs in, used for the default identity
+	Name        string  // the name of the election, used by the leader election for logging. Defaults to the backend description
+
+	LeaseDuration time.Duration // how long non-leaders wait before they try to take over
+	RenewDeadline time.Duration // how long the leader keeps retrying to renew before it gives up
+	RetryPeriod   time.Duration // how long to wait between attempts to acquire or renew
+
+	// ReleaseOnCancel releases the lock when the context of Run() is cancelled (e.g. when the pod terminates),
+	// so another instance takes over right away instead of waiting for the lease to expire.
+	// Run() cancels the context of OnStartedLeading and waits until it returned before it releases the lock
+	ReleaseOnCancel bool
+
+	// BackendTimeout is the longest a single backend call is expected to take (including retries).
+	// It is used to check that the durations leave enough room for slow backends.
+	BackendTimeout time.Duration
+
+	LockOptions LockOptions // events, transition history, metadata and eligibility of the lock. The ClusterName of the metadata defaults to ClusterName
+
+	OnStartedLeading func(ctx context.Context) // called when we become the leader. ctx is cancelled when we stop leading and carries the fencing token (see FencingTokenFromContext)
+	OnStoppedLeading func()                    // called when we stop leading
+	OnNewLeader      func(identity string)     // called when the leader changes (including to us)
+}
+
+// Runner runs a multi-cluster leader election and reports its state
+type Runner interface {
+	// Run runs the leader election until ctx is cancelled or we lose the leadership
+	Run(ctx context.Context)
+
+	// Identity returns our identity
+	Identity() string
+
+	// Leader returns the identity of the last observed leader or "" if there is none yet
+	Leader() string
+
+	// IsLeader returns true if we are the leader
+	IsLeader() bool
+
+	// FencingToken returns the fencing token of our current leadership or 0 if we aren't the leader
+	FencingToken() int64
+}
+
+type runner struct {
+	identity         string
+	lock             FencingLock
+	elector          *leaderelection.LeaderElector
+	onStartedLeading func(ctx context.Context)
+
+	// The work of the current leadership, so Run() can stop it before the leader election releases the lock
+	m        sync.Mutex
+	acting   *leadership
+	stopping bool
+}
+
+// leadership is the work we do while we lead
+type leadership struct {
+	cancel context.CancelFunc // stops the work
+	done   chan struct{}      // closed when the work stopped
+}
+
+// begin records the work of a new leadership. It returns false if Run() is stopping
+func (r *runner) begin(l *leadership) bool {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	if r.stopping {
+		return false
+	}
+	r.acting = l
+	return true
+}
+
+// stop stops the work of the current leadership (if any) and waits until it stopped
+func (r *runner) stop() {
+	r.m.Lock()
+	r.stopping = true
+	l := r.acting
+	r.m.Unlock()
+
+	if l != nil {
+		l.cancel()
+		<-l.done
+	}
+}
+
+// lead runs OnStartedLeading until ctx is cancelled or Run() stops it
+func (r *runner) lead(ctx context.Context) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	l := &leadership{cancel: cancel, done: make(chan struct{})}
+	defer close(l.done)
+	if !r.begin(l) {
+		return
+	}
+
+	if r.onStartedLeading != nil {
+		r.onStartedLeading(withFencingToken(ctx, r.lock.FencingToken()))
+	}
+}
+
+func (r *runner) Run(ctx context.Context) {
+	r.m.Lock()
+	r.stopping = false
+	r.m.Unlock()
+
+	// The leader election runs until the work stopped, so the lock is released (see ReleaseOnCancel) only after that
+	runCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	defer cancel()
+	stop := context.AfterFunc(ctx, func() {
+		r.stop()
+		cancel()
+	})
+	defer stop()
+
+	r.elector.Run(runCtx)
+}
+
+func (r *runner) Identity() string {
+	return r.identity
+}
+
+func (r *runner) Leader() string {
+	return r.elector.GetLeader()
+}
+
+func (r *runner) IsLeader() bool {
+	return r.elector.IsLeader()
+}
+
+func (r *runner) FencingToken() int64 {
+	if !r.IsLeader() {
+		return 0
+	}
+	return r.lock.FencingToken()
+}
+
+// defaultIdentity returns a unique identity made of the hostname, the cluster name and a uuid
+func defaultIdentity(clusterName string) string {
+	parts := []string{}
+	if hostname, err := os.Hostname(); err == nil && hostname != "" {
+		parts = append(parts, hostname)
+	}
+	if clusterName != "" {
+		parts = append(parts, clusterName)
+	}
+	parts = append(parts, string(uuid.NewUUID()))
+	return strings.Join(parts, "-")
+}
+
+// validateTimings checks that the durations work with a backend whose calls take up to BackendTimeout.
+//
+// The leader needs at least a Get and an Update to renew, so the renew deadline must fit two backend calls.
+// A renew that was sent just before the deadline may still land up to a backend call later,
+// so the lease must outlive the renew deadline by at least one backend call.
+func validateTimings(o RunnerOptions) (err error) {
+	switch {
+	case o.LeaseDuration <= o.RenewDeadline:
+		err = errors.Errorf("lease duration (%s) must be greater than renew deadline (%s)", o.LeaseDuration, o.RenewDeadline)
+	case o.RenewDeadline <= time.Duration(leaderelection.JitterFactor*float64(o.RetryPeriod)):
+		err = errors.Errorf("renew deadline (%s) must be greater than %.1f * retry period (%s)",
+			o.RenewDeadline, leaderelection.JitterFactor, o.RetryPeriod)
+	case o.RenewDeadline < 2*o.BackendTimeout:
+		err = errors.Errorf("renew deadline (%s) must be at least twice the backend timeout (%s)", o.RenewDeadline, o.BackendTimeout)
+	case o.LeaseDuration-o.RenewDeadline < o.BackendTimeout:
+		err = errors.Errorf("lease duration (%s) must exceed renew deadline (%s) by at least the backend timeout (%s)",
+			o.LeaseDuration, o.RenewDeadline, o.BackendTimeout)
+	}
+	return
+}
+
+// NewRunner returns a Runner that elects a leader among all the instances that share the backend
+func NewRunner(options RunnerOptions) (r Runner, err error) {
+	if options.Backend == nil {
+		err = errors.New("backend can't be nil")
+		return
+	}
+
+	if options.Identity == "" {
+		options.Identity = defaultIdentity(options.ClusterName)
+	}
+	if options.Name == "" {
+		options.Name = options.Backend.Describe()
+	}
+	if options.LeaseDuration == 0 {
+		options.LeaseDuration = defaultLeaseDuration
+	}
+	if options.RenewDeadline == 0 {
+		options.RenewDeadline = defaultRenewDeadline
+	}
+	if options.RetryPeriod == 0 {
+		options.RetryPeriod = defaultRetryPeriod
+	}
+	if options.BackendTimeout == 0 {
+		options.BackendTimeout = defaultBackendTimeout
+	}
+
+	err = validateTimings(options)
+	if err != nil {
+		return
+	}
+
+	if metadata := options.LockOptions.Metadata; metadata != nil && metadata.ClusterName == "" {
+		m := *metadata
+		m.ClusterName = options.ClusterName
+		options.LockOptions.Metadata = &m
+	}
+
+	l, err := NewLockWithOptions(options.Identity, options.Backend, options.LockOptions)
+	if err != nil {
+		return
+	}
+	rn := &runner{
+		identity:         options.Identity,
+		lock:             l.(FencingLock),
+		onStartedLeading: options.OnStartedLeading,
+	}
+
+	callbacks := leaderelection.LeaderCallbacks{
+		OnStartedLeading: rn.lead,
+		OnStoppedLeading: options.OnStoppedLeading,
+		OnNewLeader:      options.OnNewLeader,
+	}
+	if callbacks.OnStoppedLeading == nil {
+		callbacks.OnStoppedLeading = func() {}
+	}
+
+	rn.elector, err = leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock:            rn.lock,
+		Name:            options.Name,
+		LeaseDuration:   options.LeaseDuration,
+		RenewDeadline:   options.RenewDeadline,
+		RetryPeriod:     options.RetryPeriod,
+		Callbacks:       callbacks,
+		ReleaseOnCancel: options.ReleaseOnCancel,
+	})
+	if err != nil {
+		return
+	}
+
+	r = rn
+	return
+}
@@ -0,0 +1,169 @@
+package multi_cluster_lock
+
+import (
+	"context"
+	"os"
+	"sync/atomic"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Runner", func() {
+	fastOptions := func(backend Backend, identity string) RunnerOptions {
+		return RunnerOptions{
+			Backend:        backend,
+			Identity:       identity,
+			LeaseDuration:  time.Second,
+			RenewDeadline:  600 * time.Millisecond,
+			RetryPeriod:    100 * time.Millisecond,
+			BackendTimeout: 100 * time.Millisecond,
+		}
+	}
+
+	It("should require a backend", func() {
+		_, err := NewRunner(RunnerOptions{})
+		Ω(err).ShouldNot(BeNil())
+	})
+
+	It("should default to durations that suit Github gists", func() {
+		r, err := NewRunner(RunnerOptions{Backend: NewMemoryBackend()})
+		Ω(err).Should(BeNil())
+		Ω(r.IsLeader()).Should(BeFalse())
+		Ω(r.Leader()).Should(BeEmpty())
+	})
+
+	It("should default the identity to hostname, cluster name and a uuid", func() {
+		hostname, err := os.Hostname()
+		Ω(err).Should(BeNil())
+
+		r1, err := NewRunner(RunnerOptions{Backend: NewMemoryBackend(), ClusterName: "us-east"})
+		Ω(err).Should(BeNil())
+		r2, err := NewRunner(RunnerOptions{Backend: NewMemoryBackend(), ClusterName: "us-east"})
+		Ω(err).Should(BeNil())
+
+		Ω(r1.Identity()).Should(HavePrefix(hostname + "-us-east-"))
+		Ω(r1.Identity()).ShouldNot(Equal(r2.Identity()))
+	})
+
+	It("should reject timings that don't leave room for the backend", func() {
+		options := fastOptions(NewMemoryBackend(), "me")
+		options.LeaseDuration = options.RenewDeadline
+		_, err := NewRunner(options)
+		Ω(err).Should(MatchError(ContainSubstring("must be greater than renew deadline")))
+
+		options = fastOptions(NewMemoryBackend(), "me")
+		options.BackendTimeout = time.Second
+		_, err = NewRunner(options)
+		Ω(err).Should(MatchError(ContainSubstring("twice the backend timeout")))
+
+		options = fastOptions(NewMemoryBackend(), "me")
+		options.LeaseDuration = 650 * time.Millisecond
+		_, err = NewRunner(options)
+		Ω(err).Should(MatchError(ContainSubstring("by at least the backend timeout")))
+	})
+
+	It("should elect a single leader and fail over", func() {
+		backend := NewMemoryBackend()
+
+		var leading atomic.Int32
+		var newLeader atomic.Value
+		newRunner := func(identity string) Runner {
+			options := fastOptions(backend, identity)
+			options.OnStartedLeading = func(context.Context) { leading.Add(1) }
+			options.OnStoppedLeading = func() { leading.Add(-1) }
+			options.OnNewLeader = func(identity string) { newLeader.Store(identity) }
+			r, err := NewRunner(options)
+			Ω(err).Should(BeNil())
+			return r
+		}
+
+		r1 := newRunner("me")
+		r2 := newRunner("you")
+
+		ctx1, cancel1 := context.WithCancel(context.Background())
+		DeferCleanup(cancel1)
+		go r1.Run(ctx1)
+		Eventually(r1.IsLeader, 5*time.Second).Should(BeTrue())
+
+		ctx2, cancel2 := context.WithCancel(context.Background())
+		DeferCleanup(cancel2)
+		go r2.Run(ctx2)
+		Eventually(r2.Leader, 5*time.Second).Should(Equal("me"))
+		Consistently(r2.IsLeader, 500*time.Millisecond).Should(BeFalse())
+		Ω(leading.Load()).Should(Equal(int32(1)))
+
+		cancel1()
+		Eventually(r2.IsLeader, 5*time.Second).Should(BeTrue())
+		Ω(r2.Leader()).Should(Equal("you"))
+		Eventually(newLeader.Load, time.Second).Should(Equal("you"))
+	})
+
+	It("should hand over right away when the leader releases on cancel", func() {
+		backend := NewMemoryBackend()
+		newRunner := func(identity string) Runner {
+			options := fastOptions(backend, identity)
+			options.LeaseDuration = 10 * time.Second
+			options.ReleaseOnCancel = true
+			r, err := NewRunner(options)
+			Ω(err).Should(BeNil())
+			return r
+		}
+
+		r1 := newRunner("me")
+		ctx1, cancel1 := context.WithCancel(context.Background())
+		DeferCleanup(cancel1)
+		go r1.Run(ctx1)
+		Eventually(r1.IsLeader, 5*time.Second).Should(BeTrue())
+
+		r2 := newRunner("you")
+		ctx2, cancel2 := context.WithCancel(context.Background())
+		DeferCleanup(cancel2)
+		go r2.Run(ctx2)
+		Eventually(r2.Leader, 5*time.Second).Should(Equal("me"))
+
+		// Well within the lease of 10 seconds
+		cancel1()
+		Eventually(r2.IsLeader, 2*time.Second).Should(BeTrue())
+	})
+
+	It("should release the lock only after OnStartedLeading returned", func() {
+		backend := NewMemoryBackend()
+		finish := make(chan struct{})
+		var returned atomic.Bool
+		options := fastOptions(backend, "me")
+		options.LeaseDuration = 10 * time.Second
+		options.ReleaseOnCancel = true
+		options.OnStartedLeading = func(ctx context.Context) {
+			<-ctx.Done()
+			<-finish
+			returned.Store(true)
+		}
+		r, err := NewRunner(options)
+		Ω(err).Should(BeNil())
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			r.Run(ctx)
+		}()
+		Eventually(r.IsLeader, 5*time.Second).Should(BeTrue())
+
+		holder := func() string {
+			ler, _, err := newTestLock(backend, "observer", LockOptions{}).Get(context.Background())
+			Ω(err).Should(BeNil())
+			return ler.HolderIdentity
+		}
+
+		cancel()
+		Consistently(holder, 500*time.Millisecond).Should(Equal("me"))
+		Ω(done).ShouldNot(BeClosed())
+
+		close(finish)
+		Eventually(done, 2*time.Second).Should(BeClosed())
+		Ω(returned.Load()).Should(BeTrue())
+		Ω(holder()).Should(BeEmpty())
+	})
+})
@@ -0,0 +1,267 @@
+package multi_cluster_lock
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// S3Options configures an S3-compatible backend
+type S3Options struct {
+	Endpoint        string       // e.g. https://s3.us-east-1.amazonaws.com or http://localhost:9000
+	Region          string       // e.g. us-east-1
+	Bucket          string       // the bucket must exist
+	Key             string       // the object key of the lock record
+	AccessKeyID     string       // credentials used to sign requests (AWS signature version 4)
+	SecretAccessKey string       //
+	SessionToken    string       // optional, for temporary credentials
+	HTTPClient      *http.Client // if nil, an http.Client with a 10 seconds timeout is used
+}
+
+// S3Error is an error response from an S3-compatible service
+type S3Error struct {
+	StatusCode int
+	Code       string
+	Message    string
+}
+
+func (e *S3Error) Error() string {
+	return fmt.Sprintf("S3 error %d: %s %s", e.StatusCode, e.Code, e.Message)
+}
+
+// s3Backend keeps the lock record in an object of an S3-compatible bucket.
+//
+// The version of the record is the ETag of the object. Writes are conditional PUTs:
+// If-None-Match: * to create the object and If-Match: <ETag> to replace it.
+// Requests use path-style addressing and are signed with AWS signature version 4.
+type s3Backend struct {
+	options S3Options
+	cli     *http.Client
+	now     func() time.Time
+}
+
+func (b *s3Backend) objectURL() string {
+	return strings.TrimSuffix(b.options.Endpoint, "/") + "/" + s3Escape(b.options.Bucket) + "/" + s3Escape(b.options.Key)
+}
+
+func (b *s3Backend) do(ctx context.Context, method string, body []byte, header http.Header) (resp *http.Response, respBody []byte, err error) {
+	req, err := http.NewRequestWithContext(ctx, method, b.objectURL(), bytes.NewReader(body))
+	if err != nil {
+		return
+	}
+
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	signS3Request(req, body, b.options, b.now())
+
+	resp, err = b.cli.Do(req)
+	if err != nil {
+		return
+	}
+
+	defer resp.Body.Close()
+	respBody, err = io.ReadAll(resp.Body)
+	return
+}
+
+func (b *s3Backend) Get(ctx context.Context) (record []byte, version string, err error) {
+	resp, body, err := b.do(ctx, http.MethodGet, nil, nil)
+	if err != nil {
+		return
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		record = body
+		version = resp.Header.Get("ETag")
+	case http.StatusNotFound:
+		err = ErrNotFound
+	default:
+		err = newS3Error(resp, body)
+	}
+	return
+}
+
+func (b *s3Backend) Update(ctx context.Context, record []byte, version string) (newVersion string, err error) {
+	header := http.Header{}
+	header.Set("Content-Type", "application/json")
+	if version == "" {
+		header.Set("If-None-Match", "*")
+	} else {
+		header.Set("If-Match", version)
+	}
+
+	resp, body, err := b.do(ctx, http.MethodPut, record, header)
+	if err != nil {
+		return
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		newVersion = resp.Header.Get("ETag")
+	// The object changed (412), a concurrent conditional write is in progress (409)
+	// or the object was deleted (404)
+	case http.StatusPreconditionFailed, http.StatusConflict, http.StatusNotFound:
+		err = ErrConflict
+	default:
+		err = newS3Error(resp, body)
+	}
+	return
+}
+
+func (b *s3Backend) Describe() string {
+	return "S3 object s3://" + b.options.Bucket + "/" + b.options.Key
+}
+
+func newS3Error(resp *http.Response, body []byte) error {
+	s3Err := &S3Error{StatusCode: resp.StatusCode}
+	var payload struct {
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	}
+	if xml.Unmarshal(body, &payload) == nil {
+		s3Err.Code = payload.Code
+		s3Err.Message = payload.Message
+	}
+	return s3Err
+}
+
+// s3Escape escapes a bucket name or object key for the URL path, keeping the slashes of the key
+func s3Escape(s string) string {
+	var b strings.Builder
+	for _, c := range []byte(s) {
+		if ('A' <= c && c <= 'Z') || ('a' <= c && c <= 'z') || ('0' <= c && c <= '9') ||
+			c == '-' || c == '_' || c == '.' || c == '~' || c == '/' {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// signS3Request signs req with AWS signature version 4.
+//
+// All the headers of req are signed, along with the host.
+// See https://docs.aws.amazon.com/AmazonS3/latest/API/sig-v4-header-based-auth.html
+func signS3Request(req *http.Request, body []byte, o S3Options, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	payloadHash := sha256Hex(body)
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if o.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", o.SessionToken)
+	}
+
+	// Canonical headers are lower case, sorted and include the host
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		headers[strings.ToLower(k)] = strings.TrimSpace(strings.Join(v, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		s3Escape(req.URL.Path),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + o.Region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+
+	key := hmacSHA256([]byte("AWS4"+o.SecretAccessKey), date)
+	key = hmacSHA256(key, o.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		o.AccessKeyID, scope, signedHeaders, signature))
+}
+
+func canonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var parts []string
+	for _, k := range keys {
+		values := query[k]
+		sort.Strings(values)
+		for _, v := range values {
+			parts = append(parts, strings.ReplaceAll(s3Escape(k), "/", "%2F")+"="+strings.ReplaceAll(s3Escape(v), "/", "%2F"))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// NewS3Backend returns a backend that keeps the lock record in an object of an S3-compatible bucket
+func NewS3Backend(options S3Options) (backend Backend, err error) {
+	if options.Endpoint == "" || options.Region == "" || options.Bucket == "" || options.Key == "" {
+		err = errors.New("endpoint, region, bucket and key are required")
+		return
+	}
+
+	u, err := url.Parse(options.Endpoint)
+	if err != nil {
+		return
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		err = errors.Errorf("invalid endpoint: %s", options.Endpoint)
+		return
+	}
+
+	cli := options.HTTPClient
+	if cli == nil {
+		cli = &http.Client{Timeout: defaultTimeout}
+	}
+
+	backend = &s3Backend{
+		options: options,
+		cli:     cli,
+		now:     time.Now,
+	}
+	return
+}
@@ -0,0 +1,164 @@
+package multi_cluster_lock
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	sealVersion = "v1"
+
+	// Algorithms of sealed records
+	algSigned    = "HS256"         // HMAC-SHA256 signature of the plain record
+	algEncrypted = "A256GCM+HS256" // AES-256-GCM encryption, then HMAC-SHA256 signature of the ciphertext
+
+	minSecretLength = 16
+)
+
+var (
+	// ErrTamperedRecord is returned when a lock record isn't signed with the secret of the lock.
+	// Someone without the secret wrote the record, or the record was modified.
+	ErrTamperedRecord = errors.New("lock record was tampered with")
+
+	// ErrEncryptedRecord is returned when a lock record is encrypted but the lock doesn't encrypt records
+	ErrEncryptedRecord = errors.New("lock record is encrypted")
+)
+
+// sealedRecord is how a signed (and possibly encrypted) record is stored in the backend
+type sealedRecord struct {
+	Sealed    string `json:"sealed"`
+	Alg       string `json:"alg"`
+	Payload   string `json:"payload"`
+	Signature string `json:"signature"`
+}
+
+// sealer signs and optionally encrypts lock records with keys derived from a secret
+type sealer struct {
+	signingKey []byte
+	aead       cipher.AEAD // nil if records are only signed
+}
+
+func (s *sealer) sign(alg string, payload string) string {
+	mac := hmac.New(sha256.New, s.signingKey)
+	mac.Write([]byte(sealVersion + "." + alg + "." + payload))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// seal returns the sealed form of a plain record
+func (s *sealer) seal(plain []byte) (data []byte, err error) {
+	alg := algSigned
+	payload := plain
+	if s.aead != nil {
+		alg = algEncrypted
+		nonce := make([]byte, s.aead.NonceSize())
+		_, err = rand.Read(nonce)
+		if err != nil {
+			return
+		}
+		payload = s.aead.Seal(nonce, nonce, plain, []byte(sealVersion))
+	}
+
+	encoded := base64.StdEncoding.EncodeToString(payload)
+	data, err = json.Marshal(sealedRecord{
+		Sealed:    sealVersion,
+		Alg:       alg,
+		Payload:   encoded,
+		Signature: s.sign(alg, encoded),
+	})
+	return
+}
+
+// open verifies a sealed record and returns the plain record.
+//
+// Data that isn't JSON is returned as a JSON error, like any other malformed record.
+// Anything else that isn't a record sealed with our secret is ErrTamperedRecord.
+func (s *sealer) open(data []byte) (plain []byte, err error) {
+	var sealed sealedRecord
+	err = json.Unmarshal(data, &sealed)
+	if err != nil {
+		return
+	}
+
+	if sealed.Sealed != sealVersion || sealed.Payload == "" {
+		err = errors.Wrap(ErrTamperedRecord, "record is not sealed")
+		return
+	}
+
+	expected := s.sign(sealed.Alg, sealed.Payload)
+	if !hmac.Equal([]byte(expected), []byte(sealed.Signature)) {
+		err = errors.Wrap(ErrTamperedRecord, "invalid signature")
+		return
+	}
+
+	payload, err := base64.StdEncoding.DecodeString(sealed.Payload)
+	if err != nil {
+		err = errors.Wrap(ErrTamperedRecord, err.Error())
+		return
+	}
+
+	switch sealed.Alg {
+	case algSigned:
+		plain = payload
+	case algEncrypted:
+		if s.aead == nil {
+			err = ErrEncryptedRecord
+			return
+		}
+
+		nonceSize := s.aead.NonceSize()
+		if len(payload) < nonceSize {
+			err = errors.Wrap(ErrTamperedRecord, "payload is too short")
+			return
+		}
+
+		plain, err = s.aead.Open(nil, payload[:nonceSize], payload[nonceSize:], []byte(sealVersion))
+		if err != nil {
+			err = errors.Wrap(ErrTamperedRecord, err.Error())
+		}
+	default:
+		err = errors.Wrapf(ErrTamperedRecord, "unknown algorithm %s", sealed.Alg)
+	}
+	return
+}
+
+// newSealer derives the signing key (and the encryption key if encrypt is true) from secret.
+// Surrounding whitespace (e.g. the trailing newline of a file) isn't part of the secret
+func newSealer(secret []byte, encrypt bool) (s *sealer, err error) {
+	secret = bytes.TrimSpace(secret)
+	if len(secret) < minSecretLength {
+		err = errors.Errorf("secret must be at least %d bytes long", minSecretLength)
+		return
+	}
+
+	signingKey, err := hkdf.Key(sha256.New, secret, nil, "multi-cluster-lock signing", 32)
+	if err != nil {
+		return
+	}
+
+	s = &sealer{signingKey: signingKey}
+	if !encrypt {
+		return
+	}
+
+	encryptionKey, err := hkdf.Key(sha256.New, secret, nil, "multi-cluster-lock encryption", 32)
+	if err != nil {
+		return
+	}
+
+	block, err := aes.NewCipher(encryptionKey)
+	if err != nil {
+		return
+	}
+
+	s.aead, err = cipher.NewGCM(block)
+	return
+}
@@ -0,0 +1,152 @@
+package multi_cluster_lock
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/api/errors"
+)
+
+var _ = Describe("Sealed records", func() {
+	ctx := context.Background()
+	secret := []byte("0123456789abcdef0123456789abcdef")
+	var backend Backend
+
+	stored := func() string {
+		data, _, err := backend.Get(ctx)
+		Ω(err).Should(BeNil())
+		return string(data)
+	}
+
+	BeforeEach(func() {
+		backend = NewMemoryBackend()
+	})
+
+	It("should validate the options", func() {
+		_, err := NewLockWithOptions("me", backend, LockOptions{Secret: []byte("short")})
+		Ω(err).ShouldNot(BeNil())
+
+		_, err = NewLockWithOptions("me", backend, LockOptions{Encrypt: true})
+		Ω(err).ShouldNot(BeNil())
+	})
+
+	It("should sign the record", func() {
+		lock := newTestLock(backend, "me", LockOptions{Secret: secret})
+		err := lock.Create(ctx, newRecord("me", time.Now()))
+		Ω(err).Should(BeNil())
+		Ω(stored()).Should(ContainSubstring(`"alg":"HS256"`))
+
+		ler, _, err := lock.Get(ctx)
+		Ω(err).Should(BeNil())
+		Ω(ler.HolderIdentity).Should(Equal("me"))
+	})
+
+	It("should ignore whitespace around the secret", func() {
+		lock := newTestLock(backend, "me", LockOptions{Secret: secret, Encrypt: true})
+		Ω(lock.Create(ctx, newRecord("me", time.Now()))).Should(Succeed())
+
+		fromFile := newTestLock(backend, "other", LockOptions{Secret: append(append([]byte{}, secret...), '\n'), Encrypt: true})
+		ler, _, err := fromFile.Get(ctx)
+		Ω(err).Should(BeNil())
+		Ω(ler.HolderIdentity).Should(Equal("me"))
+
+		_, err = NewLockWithOptions("me", backend, LockOptions{Secret: []byte("  short         \n")})
+		Ω(err).ShouldNot(BeNil())
+	})
+
+	It("should encrypt the record", func() {
+		lock := newTestLock(backend, "my-secret-cluster", LockOptions{Secret: secret, Encrypt: true})
+		err := lock.Create(ctx, newRecord("my-secret-cluster", time.Now()))
+		Ω(err).Should(BeNil())
+		Ω(stored()).ShouldNot(ContainSubstring("my-secret-cluster"))
+
+		other := newTestLock(backend, "other", LockOptions{Secret: secret, Encrypt: true})
+		ler, _, err := other.Get(ctx)
+		Ω(err).Should(BeNil())
+		Ω(ler.HolderIdentity).Should(Equal("my-secret-cluster"))
+
+		signOnly := newTestLock(backend, "other", LockOptions{Secret: secret})
+		_, _, err = signOnly.Get(ctx)
+		Ω(err).ShouldNot(BeNil())
+		Ω(IsTampered(err)).Should(BeFalse())
+	})
+
+	It("should reject a modified record", func() {
+		lock := newTestLock(backend, "me", LockOptions{Secret: secret})
+		err := lock.Create(ctx, newRecord("me", time.Now()))
+		Ω(err).Should(BeNil())
+
+		data, version, err := backend.Get(ctx)
+		Ω(err).Should(BeNil())
+		var sealed sealedRecord
+		err = json.Unmarshal(data, &sealed)
+		Ω(err).Should(BeNil())
+
+		forged, err := json.Marshal(lockRecord{LeaderElectionRecord: newRecord("mallory", time.Now())})
+		Ω(err).Should(BeNil())
+		sealed.Payload = string(forged)
+		data, err = json.Marshal(sealed)
+		Ω(err).Should(BeNil())
+		_, err = backend.Update(ctx, data, version)
+		Ω(err).Should(BeNil())
+
+		_, _, err = lock.Get(ctx)
+		Ω(IsTampered(err)).Should(BeTrue())
+		Ω(errors.IsNotFound(err)).Should(BeFalse())
+		Ω(errors.ReasonForError(err)).Should(BeEquivalentTo("Tampered"))
+	})
+
+	It("should reject records signed with another secret or not signed at all", func() {
+		mallory := newTestLock(backend, "mallory", LockOptions{Secret: []byte("not the secret of the lock")})
+		err := mallory.Create(ctx, newRecord("mallory", time.Now()))
+		Ω(err).Should(BeNil())
+
+		lock := newTestLock(backend, "me", LockOptions{Secret: secret})
+		_, _, err = lock.Get(ctx)
+		Ω(IsTampered(err)).Should(BeTrue())
+
+		backend = NewMemoryBackend()
+		plain := newTestLock(backend, "mallory", LockOptions{})
+		err = plain.Create(ctx, newRecord("mallory", time.Now()))
+		Ω(err).Should(BeNil())
+
+		lock = newTestLock(backend, "me", LockOptions{Secret: secret})
+		_, _, err = lock.Get(ctx)
+		Ω(IsTampered(err)).Should(BeTrue())
+
+		// The leader election can't replace a tampered record either
+		err = lock.Create(ctx, newRecord("me", time.Now()))
+		Ω(IsTampered(err)).Should(BeTrue())
+		err = lock.Update(ctx, newRecord("me", time.Now()))
+		Ω(IsTampered(err)).Should(BeTrue())
+	})
+
+	It("should replace a malformed record", func() {
+		_, err := backend.Update(ctx, []byte("multi-cluster locks"), "")
+		Ω(err).Should(BeNil())
+
+		lock := newTestLock(backend, "me", LockOptions{Secret: secret})
+		err = lock.Create(ctx, newRecord("me", time.Now()))
+		Ω(err).Should(BeNil())
+	})
+
+	It("should let the inspector read and write sealed records", func() {
+		options := LockOptions{Secret: secret, Encrypt: true}
+		lock := newTestLock(backend, "me", options)
+		err := lock.Create(ctx, newRecord("me", time.Now()))
+		Ω(err).Should(BeNil())
+
+		in, err := NewInspector(backend, options)
+		Ω(err).Should(BeNil())
+		err = in.Release(ctx, Audit{Operator: "alice", Reason: "testing"})
+		Ω(err).Should(BeNil())
+
+		status, err := in.Status(ctx)
+		Ω(err).Should(BeNil())
+		Ω(status.HolderIdentity).Should(BeEmpty())
+		Ω(stored()).ShouldNot(ContainSubstring("alice"))
+	})
+})
@@ -0,0 +1,461 @@
+package multi_cluster_lock
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	pkgerrors "github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// Defaults of a semaphore. Like the ones of the Runner they suit slow backends like Github gists
+	defaultSemaphoreLeaseDuration = defaultLeaseDuration
+	defaultSemaphoreRetryPeriod   = defaultRetryPeriod
+)
+
+// ErrSlotLost is returned when renewing a semaphore slot we don't hold (anymore),
+// e.g. because our lease expired and another instance took the slot
+var ErrSlotLost = pkgerrors.New("semaphore slot is not held")
+
+// SemaphoreHolder is a holder of a semaphore slot
+type SemaphoreHolder struct {
+	Identity             string      `json:"holderIdentity"`
+	AcquireTime          metav1.Time `json:"acquireTime"`
+	RenewTime            metav1.Time `json:"renewTime"`
+	LeaseDurationSeconds int         `json:"leaseDurationSeconds"`
+	FencingToken         int64       `json:"fencingToken"`
+}
+
+// semaphoreRecord is the record of a semaphore in the backend. All the slots are kept in one record,
+// so every change is a single compare-and-swap write
+type semaphoreRecord struct {
+	Limit   int               `json:"limit"`
+	Holders []SemaphoreHolder `json:"holders"`
+
+	// Sequence is the last fencing token handed out. Every acquisition of a slot gets the next one
+	Sequence int64 `json:"sequence"`
+}
+
+// SemaphoreOptions configures a Semaphore. Only the Limit is required
+type SemaphoreOptions struct {
+	Limit         int           // the number of slots, i.e. how many instances may hold the semaphore at the same time
+	LeaseDuration time.Duration // how long a slot is held without renewing it. Defaults to 60 seconds
+	RetryPeriod   time.Duration // how long Acquire() waits between attempts and Hold() between renewals. Defaults to 10 seconds
+
+	LockOptions LockOptions // metrics, events, secret, skew allowance and clock, like the options of a lock
+}
+
+// Semaphore lets at most Limit instances across all clusters hold it at the same time.
+//
+// It keeps the holders in one record in a Backend, each with its own lease. A holder that doesn't renew
+// its lease loses its slot when the lease expires. Like the updates of a lock, the writes are compare-and-swap
+// and fail fast with a Conflict if someone else changed the record since it was read.
+// Backend errors are returned as Kubernetes API errors, like the errors of a lock.
+type Semaphore interface {
+	// TryAcquire takes a free slot (or a slot whose lease expired) and returns true,
+	// or returns false if all the slots are taken. If we already hold a slot, it is renewed.
+	TryAcquire(ctx context.Context) (acquired bool, err error)
+
+	// Acquire waits until it takes a slot or ctx is done. Conflicts and full semaphores are retried every RetryPeriod
+	Acquire(ctx context.Context) (err error)
+
+	// Renew extends the lease of our slot. It returns ErrSlotLost if we don't hold a slot
+	Renew(ctx context.Context) (err error)
+
+	// Release gives up our slot, so another instance can take it right away. It is a no-op if we don't hold a slot
+	Release(ctx context.Context) (err error)
+
+	// Hold acquires a slot, runs work while renewing it every RetryPeriod and releases it when work returns.
+	//
+	// The ctx of work is cancelled when ctx is done or the slot can't be renewed before its lease expires,
+	// and Hold returns after work returns. It returns ctx.Err(), ErrSlotLost or the error of the last renewal if it gave up.
+	Hold(ctx context.Context, work func(ctx context.Context)) (err error)
+
+	// Holders returns the holders whose leases are valid
+	Holders(ctx context.Context) (holders []SemaphoreHolder, err error)
+
+	// FencingToken returns the fencing token of our current slot or 0 if we don't hold one
+	FencingToken() int64
+
+	// Identity returns our identity
+	Identity() string
+}
+
+type semaphore struct {
+	lock    *gistLock // the backend, secret, metrics, events and clock
+	options SemaphoreOptions
+
+	// The renewals of the holders we saw last and when we first saw them (by our clock), by identity
+	m        sync.Mutex
+	observed map[string]holderObservation
+}
+
+// holderObservation is a renewal of a holder and when we first saw it (by our clock)
+type holderObservation struct {
+	renewTime    time.Time
+	fencingToken int64
+	time         time.Time
+}
+
+// observe records the renewals of the holders of record. Every holder is a lease of its own,
+// so a renewal of one holder doesn't extend the leases of the others like a new version of the record would.
+func (s *semaphore) observe(record *semaphoreRecord) {
+	now := s.lock.clock.Now()
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	observed := make(map[string]holderObservation, len(record.Holders))
+	for _, h := range record.Holders {
+		o, ok := s.observed[h.Identity]
+		if !ok || !o.renewTime.Equal(h.RenewTime.Time) || o.fencingToken != h.FencingToken {
+			o = holderObservation{renewTime: h.RenewTime.Time, fencingToken: h.FencingToken, time: now}
+		}
+		observed[h.Identity] = o
+	}
+	s.observed = observed
+}
+
+// expired returns true if the lease of holder expired by our clock. Like a lock, it measures the lease from
+// when we first saw the renewal as well (see gistLock.leaseExpiresAt()), so a holder whose clock runs behind
+// ours doesn't lose its slot early, even on our first read
+func (s *semaphore) expired(holder SemaphoreHolder, now time.Time) bool {
+	var observedTime time.Time
+	s.m.Lock()
+	if o, ok := s.observed[holder.Identity]; ok && o.renewTime.Equal(holder.RenewTime.Time) && o.fencingToken == holder.FencingToken {
+		observedTime = o.time
+	}
+	s.m.Unlock()
+
+	leaseDuration := time.Duration(holder.LeaseDurationSeconds) * time.Second
+	return !s.lock.leaseExpiry(holder.RenewTime.Time, leaseDuration, observedTime).After(now)
+}
+
+// get returns the current record and its version.
+//
+// A missing or malformed record (e.g. the initial content of a new gist) is returned as an empty record,
+// so the next write replaces it. A record with a different limit is an error.
+// Like a new lock record, a missing record continues the sequence of a backend that is a Sequencer.
+func (s *semaphore) get(ctx context.Context) (record *semaphoreRecord, version string, err error) {
+	data, version, err := s.lock.backendGet(ctx)
+	if pkgerrors.Is(err, ErrNotFound) {
+		record = &semaphoreRecord{Limit: s.options.Limit}
+		err = nil
+		if sequencer, ok := s.lock.backend.(Sequencer); ok {
+			record.Sequence, err = sequencer.Sequence(ctx)
+		}
+		return
+	}
+	if err != nil {
+		return
+	}
+
+	plain, err := s.lock.open(data)
+	if err != nil {
+		return
+	}
+
+	record = &semaphoreRecord{}
+	err = json.Unmarshal(plain, record)
+	if isMalformed(err) {
+		record = &semaphoreRecord{Limit: s.options.Limit}
+		err = nil
+		return
+	}
+	if err != nil {
+		return
+	}
+
+	if record.Limit != s.options.Limit {
+		err = pkgerrors.Errorf("semaphore has a limit of %d, not %d", record.Limit, s.options.Limit)
+		return
+	}
+
+	s.observe(record)
+	return
+}
+
+// put writes record if nobody else updated it since version
+func (s *semaphore) put(ctx context.Context, record *semaphoreRecord, version string) (err error) {
+	plain, err := json.Marshal(record)
+	if err != nil {
+		return
+	}
+
+	data, err := s.lock.seal(plain)
+	if err != nil {
+		return
+	}
+
+	// The record may expire once the longest lease of its holders did
+	var ttl time.Duration
+	for _, h := range record.Holders {
+		ttl = max(ttl, time.Duration(h.LeaseDurationSeconds)*time.Second)
+	}
+
+	_, err = s.lock.backendUpdate(ctx, data, version, ttl)
+	return
+}
+
+// holder returns the index of our slot in record or -1 if we don't hold one
+func (s *semaphore) holder(record *semaphoreRecord) int {
+	return slices.IndexFunc(record.Holders, func(h SemaphoreHolder) bool {
+		return h.Identity == s.lock.identity
+	})
+}
+
+// dropExpired removes the holders whose leases expired, except us
+func (s *semaphore) dropExpired(record *semaphoreRecord, now time.Time) {
+	record.Holders = slices.DeleteFunc(record.Holders, func(h SemaphoreHolder) bool {
+		return h.Identity != s.lock.identity && s.expired(h, now)
+	})
+}
+
+func (s *semaphore) TryAcquire(ctx context.Context) (acquired bool, err error) {
+	operation := operationAcquire
+	defer func() { s.lock.options.Metrics.observeAttempt(operation, err) }()
+
+	record, version, err := s.get(ctx)
+	if err != nil {
+		err = s.lock.toAPIError(err)
+		return
+	}
+
+	now := s.lock.clock.Now()
+	s.dropExpired(record, now)
+
+	i := s.holder(record)
+	if i >= 0 {
+		operation = operationRenew
+		record.Holders[i].RenewTime = metav1.Time{Time: now}
+		record.Holders[i].LeaseDurationSeconds = int(s.options.LeaseDuration.Seconds())
+	} else {
+		if len(record.Holders) >= record.Limit {
+			return
+		}
+
+		record.Sequence++
+		record.Holders = append(record.Holders, SemaphoreHolder{
+			Identity:             s.lock.identity,
+			AcquireTime:          metav1.Time{Time: now},
+			RenewTime:            metav1.Time{Time: now},
+			LeaseDurationSeconds: int(s.options.LeaseDuration.Seconds()),
+			FencingToken:         record.Sequence,
+		})
+		i = len(record.Holders) - 1
+	}
+
+	err = s.put(ctx, record, version)
+	if err != nil {
+		err = s.lock.toAPIError(err)
+		return
+	}
+
+	if s.lock.token.Swap(record.Holders[i].FencingToken) == 0 {
+		s.lock.RecordEvent(fmt.Sprintf("acquired a semaphore slot (%d/%d)", len(record.Holders), record.Limit))
+	}
+	acquired = true
+	return
+}
+
+func (s *semaphore) Acquire(ctx context.Context) (err error) {
+	ticker := time.NewTicker(s.options.RetryPeriod)
+	defer ticker.Stop()
+
+	for {
+		var acquired bool
+		acquired, err = s.TryAcquire(ctx)
+		if acquired {
+			return
+		}
+
+		// Conflicts are expected when many instances compete for the slots. Anything else is reported
+		if err != nil && !errors.IsConflict(err) {
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			err = ctx.Err()
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *semaphore) Renew(ctx context.Context) (err error) {
+	defer func() { s.lock.options.Metrics.observeAttempt(operationRenew, err) }()
+
+	record, version, err := s.get(ctx)
+	if err != nil {
+		err = s.lock.toAPIError(err)
+		return
+	}
+
+	i := s.holder(record)
+	if i < 0 {
+		s.lock.token.Store(0)
+		err = ErrSlotLost
+		return
+	}
+
+	now := s.lock.clock.Now()
+	record.Holders[i].RenewTime = metav1.Time{Time: now}
+	record.Holders[i].LeaseDurationSeconds = int(s.options.LeaseDuration.Seconds())
+	s.dropExpired(record, now)
+
+	err = s.put(ctx, record, version)
+	err = s.lock.toAPIError(err)
+	return
+}
+
+func (s *semaphore) Release(ctx context.Context) (err error) {
+	defer func() { s.lock.options.Metrics.observeAttempt(operationRelease, err) }()
+
+	record, version, err := s.get(ctx)
+	if err != nil {
+		err = s.lock.toAPIError(err)
+		return
+	}
+
+	i := s.holder(record)
+	if i < 0 {
+		s.lock.token.Store(0)
+		return
+	}
+
+	record.Holders = slices.Delete(record.Holders, i, i+1)
+	err = s.put(ctx, record, version)
+	if err != nil {
+		err = s.lock.toAPIError(err)
+		return
+	}
+
+	s.lock.token.Store(0)
+	s.lock.RecordEvent("released a semaphore slot")
+	return
+}
+
+func (s *semaphore) Hold(ctx context.Context, work func(ctx context.Context)) (err error) {
+	err = s.Acquire(ctx)
+	if err != nil {
+		return
+	}
+
+	workCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		work(workCtx)
+	}()
+
+	ticker := time.NewTicker(s.options.RetryPeriod)
+	defer ticker.Stop()
+
+	lastRenew := s.lock.clock.Now()
+loop:
+	for {
+		select {
+		case <-done:
+			break loop
+		case <-ctx.Done():
+			err = ctx.Err()
+			break loop
+		case <-ticker.C:
+			renewErr := s.Renew(ctx)
+			if renewErr == nil {
+				lastRenew = s.lock.clock.Now()
+				continue
+			}
+
+			// Give up if the slot is gone or the next renewal would come after the lease expired
+			if pkgerrors.Is(renewErr, ErrSlotLost) || s.lock.clock.Since(lastRenew)+s.options.RetryPeriod >= s.options.LeaseDuration {
+				err = renewErr
+				break loop
+			}
+		}
+	}
+
+	cancel()
+	<-done
+
+	// ctx may be done already, so release with a fresh one
+	releaseCtx, cancelRelease := context.WithTimeout(context.Background(), defaultBackendTimeout)
+	defer cancelRelease()
+	_ = s.Release(releaseCtx)
+	return
+}
+
+func (s *semaphore) Holders(ctx context.Context) (holders []SemaphoreHolder, err error) {
+	record, _, err := s.get(ctx)
+	if err != nil {
+		err = s.lock.toAPIError(err)
+		return
+	}
+
+	now := s.lock.clock.Now()
+	holders = slices.DeleteFunc(record.Holders, func(h SemaphoreHolder) bool {
+		return s.expired(h, now)
+	})
+	return
+}
+
+func (s *semaphore) FencingToken() int64 {
+	return s.lock.FencingToken()
+}
+
+func (s *semaphore) Identity() string {
+	return s.lock.identity
+}
+
+// NewSemaphore returns a Semaphore that keeps its record in backend
+func NewSemaphore(identity string, backend Backend, options SemaphoreOptions) (sem Semaphore, err error) {
+	if identity == "" {
+		err = pkgerrors.New("identity can't be empty")
+		return
+	}
+
+	if options.Limit <= 0 {
+		err = pkgerrors.New("limit must be positive")
+		return
+	}
+	if options.LeaseDuration == 0 {
+		options.LeaseDuration = defaultSemaphoreLeaseDuration
+	}
+	if options.RetryPeriod == 0 {
+		options.RetryPeriod = defaultSemaphoreRetryPeriod
+	}
+	if options.LeaseDuration < time.Second || options.RetryPeriod <= 0 || options.RetryPeriod >= options.LeaseDuration {
+		err = pkgerrors.Errorf("lease duration (%s) must be at least a second and greater than the retry period (%s)",
+			options.LeaseDuration, options.RetryPeriod)
+		return
+	}
+
+	lock, err := NewLockWithOptions(identity, backend, options.LockOptions)
+	if err != nil {
+		return
+	}
+
+	sem = &semaphore{
+		lock:    lock.(*gistLock),
+		options: options,
+	}
+	return
+}
+
+// NewGistSemaphore returns a Semaphore with limit slots that keeps its record in the file filename of the gist gistId
+func NewGistSemaphore(identity string, gistId string, filename string, accessToken string, limit int) (sem Semaphore, err error) {
+	backend, err := NewGistBackend(gistId, filename, accessToken)
+	if err != nil {
+		return
+	}
+
+	return NewSemaphore(identity, backend, SemaphoreOptions{Limit: limit})
+}
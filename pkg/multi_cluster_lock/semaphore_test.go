@@ -0,0 +1,254 @@
+package multi_cluster_lock
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/api/errors"
+	clocktesting "k8s.io/utils/clock/testing"
+)
+
+var _ = Describe("Semaphore", func() {
+	ctx := context.Background()
+	var backend Backend
+	var fakeClock *clocktesting.FakePassiveClock
+
+	newSemaphore := func(identity string, limit int) Semaphore {
+		sem, err := NewSemaphore(identity, backend, SemaphoreOptions{
+			Limit:         limit,
+			LeaseDuration: 10 * time.Second,
+			RetryPeriod:   10 * time.Millisecond,
+			LockOptions:   LockOptions{Clock: fakeClock},
+		})
+		Ω(err).Should(BeNil())
+		return sem
+	}
+
+	BeforeEach(func() {
+		backend = NewMemoryBackend()
+		fakeClock = newTestClock()
+	})
+
+	It("should let at most limit holders acquire it", func() {
+		for i := 1; i <= 2; i++ {
+			sem := newSemaphore(fmt.Sprintf("holder-%d", i), 2)
+			acquired, err := sem.TryAcquire(ctx)
+			Ω(err).Should(BeNil())
+			Ω(acquired).Should(BeTrue())
+			Ω(sem.FencingToken()).Should(Equal(int64(i)))
+		}
+
+		sem := newSemaphore("holder-3", 2)
+		acquired, err := sem.TryAcquire(ctx)
+		Ω(err).Should(BeNil())
+		Ω(acquired).Should(BeFalse())
+		Ω(sem.FencingToken()).Should(BeZero())
+
+		holders, err := sem.Holders(ctx)
+		Ω(err).Should(BeNil())
+		Ω(holders).Should(HaveLen(2))
+	})
+
+	It("should free the slot of a holder whose lease expired", func() {
+		first := newSemaphore("holder-1", 1)
+		_, err := first.TryAcquire(ctx)
+		Ω(err).Should(BeNil())
+
+		second := newSemaphore("holder-2", 1)
+		acquired, err := second.TryAcquire(ctx)
+		Ω(err).Should(BeNil())
+		Ω(acquired).Should(BeFalse())
+
+		fakeClock.SetTime(fakeClock.Now().Add(11 * time.Second))
+		acquired, err = second.TryAcquire(ctx)
+		Ω(err).Should(BeNil())
+		Ω(acquired).Should(BeTrue())
+		Ω(second.FencingToken()).Should(Equal(int64(2)))
+
+		err = first.Renew(ctx)
+		Ω(err).Should(MatchError(ErrSlotLost))
+		Ω(first.FencingToken()).Should(BeZero())
+	})
+
+	It("should not free the slot of a live holder on its first read, even with its clock ahead", func() {
+		first := newSemaphore("holder-1", 1)
+		_, err := first.TryAcquire(ctx)
+		Ω(err).Should(BeNil())
+
+		// The clock of the second holder is 30 seconds ahead, so the RenewTime of the first looks expired
+		aheadClock := clocktesting.NewFakePassiveClock(fakeClock.Now().Add(30 * time.Second))
+		second, err := NewSemaphore("holder-2", backend, SemaphoreOptions{
+			Limit:         1,
+			LeaseDuration: 10 * time.Second,
+			RetryPeriod:   10 * time.Millisecond,
+			LockOptions:   LockOptions{Clock: aheadClock},
+		})
+		Ω(err).Should(BeNil())
+		acquired, err := second.TryAcquire(ctx)
+		Ω(err).Should(BeNil())
+		Ω(acquired).Should(BeFalse())
+
+		// The lease runs from the first read by the clock of the second holder
+		aheadClock.SetTime(aheadClock.Now().Add(11 * time.Second))
+		acquired, err = second.TryAcquire(ctx)
+		Ω(err).Should(BeNil())
+		Ω(acquired).Should(BeTrue())
+	})
+
+	It("should keep the slot of a holder that renews", func() {
+		first := newSemaphore("holder-1", 1)
+		_, err := first.TryAcquire(ctx)
+		Ω(err).Should(BeNil())
+
+		fakeClock.SetTime(fakeClock.Now().Add(8 * time.Second))
+		Ω(first.Renew(ctx)).Should(Succeed())
+
+		fakeClock.SetTime(fakeClock.Now().Add(8 * time.Second))
+		acquired, err := newSemaphore("holder-2", 1).TryAcquire(ctx)
+		Ω(err).Should(BeNil())
+		Ω(acquired).Should(BeFalse())
+	})
+
+	It("should hand a released slot over right away", func() {
+		first := newSemaphore("holder-1", 1)
+		_, err := first.TryAcquire(ctx)
+		Ω(err).Should(BeNil())
+		Ω(first.Release(ctx)).Should(Succeed())
+		Ω(first.FencingToken()).Should(BeZero())
+
+		// Releasing again is a no-op
+		Ω(first.Release(ctx)).Should(Succeed())
+
+		acquired, err := newSemaphore("holder-2", 1).TryAcquire(ctx)
+		Ω(err).Should(BeNil())
+		Ω(acquired).Should(BeTrue())
+	})
+
+	It("should fail with a Conflict if the record changed since it was read", func() {
+		_, err := newSemaphore("holder-1", 2).TryAcquire(ctx)
+		Ω(err).Should(BeNil())
+		racer, _, err := backend.Get(ctx)
+		Ω(err).Should(BeNil())
+
+		sem, err := NewSemaphore("holder-2", &racingBackend{Backend: backend, racer: racer}, SemaphoreOptions{Limit: 2})
+		Ω(err).Should(BeNil())
+		_, err = sem.TryAcquire(ctx)
+		Ω(errors.IsConflict(err)).Should(BeTrue())
+	})
+
+	It("should continue the sequence of the backend when the record is created", func() {
+		backend = &sequencedBackend{Backend: NewMemoryBackend(), seq: 41}
+		sem := newSemaphore("holder-1", 1)
+		Ω(sem.TryAcquire(ctx)).Should(BeTrue())
+		Ω(sem.FencingToken()).Should(Equal(int64(42)))
+	})
+
+	It("should reject a different limit", func() {
+		_, err := newSemaphore("holder-1", 2).TryAcquire(ctx)
+		Ω(err).Should(BeNil())
+
+		_, err = newSemaphore("holder-2", 3).TryAcquire(ctx)
+		Ω(err).ShouldNot(BeNil())
+	})
+
+	It("should wait in Acquire until a slot is free or the context is done", func() {
+		first := newSemaphore("holder-1", 1)
+		_, err := first.TryAcquire(ctx)
+		Ω(err).Should(BeNil())
+
+		second := newSemaphore("holder-2", 1)
+		timeoutCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+		defer cancel()
+		Ω(second.Acquire(timeoutCtx)).Should(MatchError(context.DeadlineExceeded))
+
+		time.AfterFunc(50*time.Millisecond, func() { _ = first.Release(ctx) })
+		Ω(second.Acquire(ctx)).Should(Succeed())
+	})
+
+	It("should keep concurrent holders within the limit", func() {
+		var running, maxRunning atomic.Int32
+		var wg sync.WaitGroup
+		for i := 0; i < 6; i++ {
+			wg.Add(1)
+			go func() {
+				defer GinkgoRecover()
+				defer wg.Done()
+
+				sem, err := NewSemaphore(fmt.Sprintf("holder-%d", i), backend, SemaphoreOptions{
+					Limit:         2,
+					LeaseDuration: 10 * time.Second,
+					RetryPeriod:   5 * time.Millisecond,
+				})
+				Ω(err).Should(BeNil())
+
+				err = sem.Hold(ctx, func(ctx context.Context) {
+					n := running.Add(1)
+					for {
+						m := maxRunning.Load()
+						if n <= m || maxRunning.CompareAndSwap(m, n) {
+							break
+						}
+					}
+					time.Sleep(20 * time.Millisecond)
+					running.Add(-1)
+				})
+				Ω(err).Should(BeNil())
+			}()
+		}
+		wg.Wait()
+
+		Ω(maxRunning.Load()).Should(Equal(int32(2)))
+		holders, err := newSemaphore("observer", 2).Holders(ctx)
+		Ω(err).Should(BeNil())
+		Ω(holders).Should(BeEmpty())
+	})
+
+	It("should stop the work when the slot is lost", func() {
+		sem := newSemaphore("holder-1", 1)
+		stopped := make(chan struct{})
+		err := sem.Hold(ctx, func(workCtx context.Context) {
+			// Another instance sees the slot expire and steals it
+			thief := newSemaphore("holder-2", 1)
+			_, err := thief.Holders(ctx)
+			Ω(err).Should(BeNil())
+			fakeClock.SetTime(fakeClock.Now().Add(11 * time.Second))
+			_, err = thief.TryAcquire(ctx)
+			Ω(err).Should(BeNil())
+
+			<-workCtx.Done()
+			close(stopped)
+		})
+		Ω(err).Should(MatchError(ErrSlotLost))
+		Ω(stopped).Should(BeClosed())
+	})
+
+	It("should stop the work when it can't renew within the lease by its clock", func() {
+		unavailable := &unavailableBackend{Backend: backend}
+		backend = unavailable
+		sem := newSemaphore("holder-1", 1)
+		err := sem.Hold(ctx, func(workCtx context.Context) {
+			unavailable.down.Store(true)
+
+			// The renewals fail, but the lease hasn't expired by the clock of the semaphore
+			Consistently(workCtx.Done(), 200*time.Millisecond).ShouldNot(BeClosed())
+
+			fakeClock.SetTime(fakeClock.Now().Add(10 * time.Second))
+			Eventually(workCtx.Done(), time.Second).Should(BeClosed())
+		})
+		Ω(err).ShouldNot(BeNil())
+		Ω(err).ShouldNot(MatchError(ErrSlotLost))
+	})
+
+	It("should reject invalid options", func() {
+		_, err := NewSemaphore("holder-1", backend, SemaphoreOptions{})
+		Ω(err).ShouldNot(BeNil())
+
+		_, err = NewSemaphore("holder-1", backend, SemaphoreOptions{Limit: 1, LeaseDuration: time.Second, RetryPeriod: time.Minute})
+		Ω(err).ShouldNot(BeNil())
+	})
+})
@@ -0,0 +1,128 @@
+package multi_cluster_lock
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	clocktesting "k8s.io/utils/clock/testing"
+)
+
+var _ = Describe("Clock skew", func() {
+	ctx := context.Background()
+	var backend Backend
+	var start time.Time
+	var leaderClock, followerClock *clocktesting.FakePassiveClock
+	var leader, follower resourcelock.Interface
+
+	// renew renews the lease of the leader by the clock of the leader
+	renew := func() {
+		ler := newRecord("leader", leaderClock.Now())
+		ler.LeaseDurationSeconds = 10
+		err := leader.Update(ctx, ler)
+		Ω(err).Should(BeNil())
+	}
+
+	// elapse moves both clocks forward
+	elapse := func(d time.Duration) {
+		leaderClock.SetTime(leaderClock.Now().Add(d))
+		followerClock.SetTime(followerClock.Now().Add(d))
+	}
+
+	takeOver := func() error {
+		return follower.Update(ctx, newRecord("follower", followerClock.Now()))
+	}
+
+	BeforeEach(func() {
+		backend = NewMemoryBackend()
+		start = time.Now().Truncate(time.Second)
+
+		// The clock of the follower is 30 seconds ahead of the clock of the leader
+		leaderClock = clocktesting.NewFakePassiveClock(start)
+		followerClock = clocktesting.NewFakePassiveClock(start.Add(30 * time.Second))
+
+		leader = newTestLock(backend, "leader", LockOptions{Clock: leaderClock})
+		ler := newRecord("leader", leaderClock.Now())
+		ler.LeaseDurationSeconds = 10
+		err := leader.Create(ctx, ler)
+		Ω(err).Should(BeNil())
+	})
+
+	It("should reject a negative skew allowance", func() {
+		_, err := NewLockWithOptions("me", backend, LockOptions{SkewAllowance: -time.Second})
+		Ω(err).ShouldNot(BeNil())
+	})
+
+	It("should not steal a live lease on its first read, even with its clock ahead", func() {
+		follower = newTestLock(backend, "follower", LockOptions{Clock: followerClock})
+		Ω(errors.IsConflict(takeOver())).Should(BeTrue())
+
+		// The lease runs from the first read by the clock of the follower
+		elapse(9 * time.Second)
+		Ω(errors.IsConflict(takeOver())).Should(BeTrue())
+		elapse(2 * time.Second)
+		Ω(takeOver()).Should(Succeed())
+	})
+
+	It("should not steal a lease it saw being renewed", func() {
+		follower = newTestLock(backend, "follower", LockOptions{Clock: followerClock})
+		_, _, err := follower.Get(ctx)
+		Ω(err).Should(BeNil())
+
+		elapse(5 * time.Second)
+		renew()
+		_, _, err = follower.Get(ctx)
+		Ω(err).Should(BeNil())
+
+		// The RenewTime is 30 seconds in the past by the clock of the follower, but it saw the renewal just now
+		elapse(5 * time.Second)
+		Ω(errors.IsConflict(takeOver())).Should(BeTrue())
+
+		// Once the leader stops renewing, the lease expires by the clock of the follower
+		elapse(6 * time.Second)
+		Ω(takeOver()).Should(Succeed())
+	})
+
+	It("should respect the skew allowance", func() {
+		follower = newTestLock(backend, "follower", LockOptions{Clock: followerClock, SkewAllowance: time.Minute})
+		Ω(errors.IsConflict(takeOver())).Should(BeTrue())
+
+		elapse(41 * time.Second)
+		Ω(takeOver()).Should(Succeed())
+	})
+
+	It("should take over from a dead leader whose clock runs ahead", func() {
+		// The clock of the leader is 5 minutes ahead of the clock of the follower
+		leaderClock.SetTime(followerClock.Now().Add(5 * time.Minute))
+		renew()
+
+		follower = newTestLock(backend, "follower", LockOptions{Clock: followerClock, SkewAllowance: time.Minute})
+		Ω(errors.IsConflict(takeOver())).Should(BeTrue())
+
+		// The RenewTime extends the lease by at most the skew allowance
+		elapse(time.Minute + 9*time.Second)
+		Ω(errors.IsConflict(takeOver())).Should(BeTrue())
+		elapse(2 * time.Second)
+		Ω(takeOver()).Should(Succeed())
+	})
+
+	It("should let the inspector use the clock and the skew allowance", func() {
+		in, err := NewInspector(backend, LockOptions{Clock: followerClock, SkewAllowance: time.Minute})
+		Ω(err).Should(BeNil())
+
+		audit := Audit{Operator: "alice", Reason: "testing"}
+		Ω(errors.IsConflict(in.Steal(ctx, "follower", audit))).Should(BeTrue())
+
+		// The status tells by the RenewTime alone, which is 30 seconds in the past by the clock of the follower
+		status, err := in.Status(ctx)
+		Ω(err).Should(BeNil())
+		Ω(status.Remaining).Should(BeZero())
+
+		// Stealing allows for a minute of skew
+		elapse(41 * time.Second)
+		Ω(in.Steal(ctx, "follower", audit)).Should(Succeed())
+	})
+})
@@ -0,0 +1,310 @@
+package multi_cluster_lock
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"k8s.io/utils/clock"
+)
+
+const (
+	// Github rejects app JWTs that are valid for more than 10 minutes. The issue time is
+	// backdated to allow for clock drift, as Github recommends.
+	appJWTBackdate = time.Minute
+	appJWTLifetime = 9 * time.Minute
+
+	// defaultTokenRefreshWindow is how long before an installation token expires it is replaced.
+	// Installation tokens are valid for an hour.
+	defaultTokenRefreshWindow = 5 * time.Minute
+)
+
+// TokenSource provides the Github API access token of a GistClient.
+//
+// Token is called before every request, so implementations should cache the token
+// and must be safe for concurrent use.
+type TokenSource interface {
+	Token(ctx context.Context) (token string, err error)
+}
+
+// cleanToken removes inadvertent whitespace (e.g. the trailing newline of a file) from a token
+func cleanToken(token string) string {
+	return strings.TrimSpace(strings.Replace(token, "\n", "", -1))
+}
+
+type staticTokenSource struct {
+	token string
+}
+
+func (s *staticTokenSource) Token(_ context.Context) (token string, err error) {
+	return s.token, nil
+}
+
+// StaticTokenSource returns a TokenSource that always provides token (e.g. a personal access token)
+func StaticTokenSource(token string) TokenSource {
+	return &staticTokenSource{token: cleanToken(token)}
+}
+
+// fileTokenSource reads the token from a file and reads it again whenever the file changes
+type fileTokenSource struct {
+	path string
+
+	m       sync.Mutex
+	token   string
+	modTime time.Time
+	size    int64
+}
+
+func (s *fileTokenSource) Token(_ context.Context) (token string, err error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	// Stat follows symlinks, so this also catches the atomic symlink swap of a mounted Secret
+	info, err := os.Stat(s.path)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to read the token file %s", s.path)
+		return
+	}
+
+	if s.token != "" && info.ModTime().Equal(s.modTime) && info.Size() == s.size {
+		token = s.token
+		return
+	}
+
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to read the token file %s", s.path)
+		return
+	}
+
+	token = cleanToken(string(data))
+	if token == "" {
+		err = errors.Errorf("token file %s is empty", s.path)
+		return
+	}
+
+	s.token = token
+	s.modTime = info.ModTime()
+	s.size = info.Size()
+	return
+}
+
+// NewFileTokenSource returns a TokenSource that reads the token from the file path, e.g. a mounted Secret.
+//
+// The file is checked before every request and read again when it changes, so rotated tokens are
+// picked up without a restart. If the file can't be read, the requests fail.
+func NewFileTokenSource(path string) (source TokenSource, err error) {
+	s := &fileTokenSource{path: path}
+	_, err = s.Token(context.Background())
+	if err != nil {
+		return
+	}
+
+	source = s
+	return
+}
+
+// GithubAppOptions identifies a Github App installation whose installation tokens access the gists
+type GithubAppOptions struct {
+	AppID          int64  // the ID of the Github App (required)
+	InstallationID int64  // the ID of the installation of the app on the account that owns the gists (required)
+	PrivateKey     []byte // the PEM encoded RSA private key of the app (required)
+
+	BaseURL       string             // Github API base URL. Defaults to https://api.github.com
+	HTTPClient    *http.Client       // if nil, an http.Client with a 10 seconds timeout is used
+	RefreshWindow time.Duration      // how long before expiry a token is replaced. Defaults to 5 minutes
+	Clock         clock.PassiveClock // defaults to the real clock. Inject a fake clock in tests
+}
+
+// appTokenSource exchanges JWTs signed with the private key of a Github App for installation tokens
+type appTokenSource struct {
+	options GithubAppOptions
+	key     *rsa.PrivateKey
+	url     string
+
+	m         sync.Mutex
+	token     string
+	expiresAt time.Time
+}
+
+// parsePrivateKey parses a PEM encoded RSA private key in PKCS#1 (what Github generates) or PKCS#8 form
+func parsePrivateKey(data []byte) (key *rsa.PrivateKey, err error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		err = errors.New("private key is not PEM encoded")
+		return
+	}
+
+	key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	if err == nil {
+		return
+	}
+
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		err = errors.Wrap(err, "failed to parse the private key")
+		return
+	}
+
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		err = errors.New("private key is not an RSA key")
+	}
+	return
+}
+
+// jwt returns a JWT that authenticates as the app, signed with RS256
+func (s *appTokenSource) jwt() (token string, err error) {
+	now := s.options.Clock.Now()
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	if err != nil {
+		return
+	}
+
+	claims, err := json.Marshal(map[string]any{
+		"iat": now.Add(-appJWTBackdate).Unix(),
+		"exp": now.Add(appJWTLifetime).Unix(),
+		"iss": strconv.FormatInt(s.options.AppID, 10),
+	})
+	if err != nil {
+		return
+	}
+
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(signingInput))
+	signature, err := rsa.SignPKCS1v15(nil, s.key, crypto.SHA256, digest[:])
+	if err != nil {
+		return
+	}
+
+	token = signingInput + "." + base64.RawURLEncoding.EncodeToString(signature)
+	return
+}
+
+// exchange requests a new installation token
+func (s *appTokenSource) exchange(ctx context.Context) (token string, expiresAt time.Time, err error) {
+	jwt, err := s.jwt()
+	if err != nil {
+		return
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(nil))
+	if err != nil {
+		return
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("Authorization", "Bearer "+jwt)
+	req.Header.Set("User-Agent", defaultUserAgent)
+	req.Header.Set("X-GitHub-Api-Version", defaultAPIVersion)
+
+	resp, err := s.options.HTTPClient.Do(req)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return
+	}
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		err = errors.Wrap(newGistError(resp, body), "failed to get an installation token")
+		return
+	}
+
+	var payload struct {
+		Token     string    `json:"token"`
+		ExpiresAt time.Time `json:"expires_at"`
+	}
+	err = json.Unmarshal(body, &payload)
+	if err != nil {
+		return
+	}
+
+	if payload.Token == "" {
+		err = errors.New("installation token response has no token")
+		return
+	}
+
+	token = payload.Token
+	expiresAt = payload.ExpiresAt
+	return
+}
+
+func (s *appTokenSource) Token(ctx context.Context) (token string, err error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if s.token != "" && s.options.Clock.Now().Add(s.options.RefreshWindow).Before(s.expiresAt) {
+		token = s.token
+		return
+	}
+
+	token, expiresAt, err := s.exchange(ctx)
+	if err != nil {
+		return
+	}
+
+	s.token = token
+	s.expiresAt = expiresAt
+	return
+}
+
+// NewGithubAppTokenSource returns a TokenSource that provides installation tokens of a Github App.
+//
+// It signs a JWT with the private key of the app, exchanges it for an installation token and replaces
+// the token before it expires, so no long-lived credentials are needed besides the private key.
+//
+// api.github.com doesn't accept installation tokens for the Gist API, since gists belong to users and
+// Github Apps have no gist permission. Use it only with a server that accepts them for gists (e.g. a proxy)
+// and a token of a user with the gist scope (see NewFileTokenSource()) for api.github.com.
+func NewGithubAppTokenSource(options GithubAppOptions) (source TokenSource, err error) {
+	if options.AppID <= 0 || options.InstallationID <= 0 {
+		err = errors.New("app ID and installation ID are required")
+		return
+	}
+
+	key, err := parsePrivateKey(options.PrivateKey)
+	if err != nil {
+		return
+	}
+
+	if options.BaseURL == "" {
+		options.BaseURL = defaultBaseURL
+	}
+	if options.HTTPClient == nil {
+		options.HTTPClient = &http.Client{Timeout: defaultTimeout}
+	}
+	if options.RefreshWindow == 0 {
+		options.RefreshWindow = defaultTokenRefreshWindow
+	}
+	if options.RefreshWindow < 0 {
+		err = errors.New("refresh window can't be negative")
+		return
+	}
+	if options.Clock == nil {
+		options.Clock = clock.RealClock{}
+	}
+
+	source = &appTokenSource{
+		options: options,
+		key:     key,
+		url:     strings.TrimSuffix(options.BaseURL, "/") + "/app/installations/" + strconv.FormatInt(options.InstallationID, 10) + "/access_tokens",
+	}
+	return
+}
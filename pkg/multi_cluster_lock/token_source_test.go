@@ -0,0 +1,241 @@
+package multi_cluster_lock
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	clocktesting "k8s.io/utils/clock/testing"
+)
+
+var _ = Describe("TokenSource", func() {
+	ctx := context.Background()
+
+	Context("static", func() {
+		It("should clean up the token", func() {
+			token, err := StaticTokenSource("token-1\n").Token(ctx)
+			Ω(err).Should(BeNil())
+			Ω(token).Should(Equal("token-1"))
+		})
+	})
+
+	Context("file", func() {
+		var path string
+
+		BeforeEach(func() {
+			path = filepath.Join(GinkgoT().TempDir(), "token")
+			Ω(os.WriteFile(path, []byte("token-1\n"), 0600)).Should(Succeed())
+		})
+
+		It("should read the token again when the file changes", func() {
+			source, err := NewFileTokenSource(path)
+			Ω(err).Should(BeNil())
+
+			token, err := source.Token(ctx)
+			Ω(err).Should(BeNil())
+			Ω(token).Should(Equal("token-1"))
+
+			Ω(os.WriteFile(path, []byte("token-22\n"), 0600)).Should(Succeed())
+			token, err = source.Token(ctx)
+			Ω(err).Should(BeNil())
+			Ω(token).Should(Equal("token-22"))
+		})
+
+		It("should follow the symlink swap of a mounted Secret", func() {
+			dir := filepath.Dir(path)
+			Ω(os.Mkdir(filepath.Join(dir, "v1"), 0700)).Should(Succeed())
+			Ω(os.Mkdir(filepath.Join(dir, "v2"), 0700)).Should(Succeed())
+			Ω(os.WriteFile(filepath.Join(dir, "v1", "token"), []byte("token-1"), 0600)).Should(Succeed())
+			Ω(os.WriteFile(filepath.Join(dir, "v2", "token"), []byte("token-2"), 0600)).Should(Succeed())
+			Ω(os.Symlink("v1", filepath.Join(dir, "..data"))).Should(Succeed())
+			link := filepath.Join(dir, "mounted")
+			Ω(os.Symlink(filepath.Join("..data", "token"), link)).Should(Succeed())
+
+			source, err := NewFileTokenSource(link)
+			Ω(err).Should(BeNil())
+			token, err := source.Token(ctx)
+			Ω(err).Should(BeNil())
+			Ω(token).Should(Equal("token-1"))
+
+			// Like the kubelet, swap the data directory atomically
+			Ω(os.Symlink("v2", filepath.Join(dir, "..data_tmp"))).Should(Succeed())
+			Ω(os.Rename(filepath.Join(dir, "..data_tmp"), filepath.Join(dir, "..data"))).Should(Succeed())
+			Ω(os.Chtimes(filepath.Join(dir, "v2", "token"), time.Now(), time.Now().Add(time.Minute))).Should(Succeed())
+
+			token, err = source.Token(ctx)
+			Ω(err).Should(BeNil())
+			Ω(token).Should(Equal("token-2"))
+		})
+
+		It("should fail if the file is missing or empty", func() {
+			_, err := NewFileTokenSource(path + "-missing")
+			Ω(err).ShouldNot(BeNil())
+
+			Ω(os.WriteFile(path, []byte("\n"), 0600)).Should(Succeed())
+			_, err = NewFileTokenSource(path)
+			Ω(err).ShouldNot(BeNil())
+		})
+
+		It("should authenticate the requests of a gist client", func() {
+			var authorization atomic.Value
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				authorization.Store(r.Header.Get("Authorization"))
+				_, _ = w.Write([]byte(`{"files": {"lock.json": {"content": "record-1"}}}`))
+			}))
+			DeferCleanup(server.Close)
+
+			source, err := NewFileTokenSource(path)
+			Ω(err).Should(BeNil())
+			cli, err := NewGistClientWithOptions("", GistClientOptions{BaseURL: server.URL, TokenSource: source})
+			Ω(err).Should(BeNil())
+
+			_, err = cli.Get(ctx, "gist-1")
+			Ω(err).Should(BeNil())
+			Ω(authorization.Load()).Should(Equal("Bearer token-1"))
+
+			Ω(os.WriteFile(path, []byte("token-22"), 0600)).Should(Succeed())
+			_, err = cli.Get(ctx, "gist-1")
+			Ω(err).Should(BeNil())
+			Ω(authorization.Load()).Should(Equal("Bearer token-22"))
+		})
+	})
+
+	Context("Github App", func() {
+		var key *rsa.PrivateKey
+		var keyPEM []byte
+		var fakeClock *clocktesting.FakePassiveClock
+		var server *httptest.Server
+		var exchanges atomic.Int32
+		var claims map[string]any
+
+		BeforeEach(func() {
+			var err error
+			key, err = rsa.GenerateKey(rand.Reader, 2048)
+			Ω(err).Should(BeNil())
+			keyPEM = pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+
+			fakeClock = clocktesting.NewFakePassiveClock(time.Now())
+			exchanges.Store(0)
+			claims = nil
+
+			// Verifies the JWT like Github does and issues installation tokens valid for an hour
+			server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method != http.MethodPost || r.URL.Path != "/app/installations/42/access_tokens" {
+					w.WriteHeader(http.StatusNotFound)
+					_, _ = w.Write([]byte(`{"message": "Not Found"}`))
+					return
+				}
+
+				parts := strings.Split(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "), ".")
+				Ω(parts).Should(HaveLen(3))
+				signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+				Ω(err).Should(BeNil())
+				digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+				if rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, digest[:], signature) != nil {
+					w.WriteHeader(http.StatusUnauthorized)
+					_, _ = w.Write([]byte(`{"message": "A JSON web token could not be decoded"}`))
+					return
+				}
+
+				payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+				Ω(err).Should(BeNil())
+				Ω(json.Unmarshal(payload, &claims)).Should(Succeed())
+
+				n := exchanges.Add(1)
+				expiresAt := fakeClock.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+				w.WriteHeader(http.StatusCreated)
+				_, _ = fmt.Fprintf(w, `{"token": "ghs_%d", "expires_at": "%s"}`, n, expiresAt)
+			}))
+			DeferCleanup(server.Close)
+		})
+
+		newSource := func() TokenSource {
+			source, err := NewGithubAppTokenSource(GithubAppOptions{
+				AppID:          7,
+				InstallationID: 42,
+				PrivateKey:     keyPEM,
+				BaseURL:        server.URL,
+				Clock:          fakeClock,
+			})
+			Ω(err).Should(BeNil())
+			return source
+		}
+
+		It("should exchange a signed JWT for an installation token", func() {
+			token, err := newSource().Token(ctx)
+			Ω(err).Should(BeNil())
+			Ω(token).Should(Equal("ghs_1"))
+
+			now := fakeClock.Now().Unix()
+			Ω(claims["iss"]).Should(Equal("7"))
+			Ω(claims["iat"]).Should(BeNumerically("<", now))
+			Ω(claims["exp"]).Should(BeNumerically("<=", now+600))
+		})
+
+		It("should cache the token and refresh it before it expires", func() {
+			source := newSource()
+			token, err := source.Token(ctx)
+			Ω(err).Should(BeNil())
+			Ω(token).Should(Equal("ghs_1"))
+
+			fakeClock.SetTime(fakeClock.Now().Add(50 * time.Minute))
+			token, err = source.Token(ctx)
+			Ω(err).Should(BeNil())
+			Ω(token).Should(Equal("ghs_1"))
+			Ω(exchanges.Load()).Should(Equal(int32(1)))
+
+			// Within the refresh window
+			fakeClock.SetTime(fakeClock.Now().Add(6 * time.Minute))
+			token, err = source.Token(ctx)
+			Ω(err).Should(BeNil())
+			Ω(token).Should(Equal("ghs_2"))
+		})
+
+		It("should accept a PKCS#8 private key", func() {
+			der, err := x509.MarshalPKCS8PrivateKey(key)
+			Ω(err).Should(BeNil())
+			keyPEM = pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+
+			_, err = newSource().Token(ctx)
+			Ω(err).Should(BeNil())
+		})
+
+		It("should surface Github errors", func() {
+			other, err := rsa.GenerateKey(rand.Reader, 2048)
+			Ω(err).Should(BeNil())
+			keyPEM = pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(other)})
+
+			_, err = newSource().Token(ctx)
+			Ω(err).Should(MatchError(ErrUnauthorized))
+		})
+
+		It("should reject invalid options", func() {
+			_, err := NewGithubAppTokenSource(GithubAppOptions{AppID: 7, PrivateKey: keyPEM})
+			Ω(err).ShouldNot(BeNil())
+
+			_, err = NewGithubAppTokenSource(GithubAppOptions{AppID: 7, InstallationID: 42, PrivateKey: []byte("not a key")})
+			Ω(err).ShouldNot(BeNil())
+		})
+	})
+
+	It("should not take both an access token and a token source", func() {
+		_, err := NewGistClientWithOptions("token", GistClientOptions{TokenSource: StaticTokenSource("token")})
+		Ω(err).ShouldNot(BeNil())
+	})
+})